@@ -3,16 +3,25 @@ package backend
 import (
 	"context"
 	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/application/calendar"
 	"github.com/fafeitsch/private-running-journal/backend/application/dashboard"
+	"github.com/fafeitsch/private-running-journal/backend/application/duplicateFinder"
 	"github.com/fafeitsch/private-running-journal/backend/application/journalEditor"
 	"github.com/fafeitsch/private-running-journal/backend/application/journalList"
 	"github.com/fafeitsch/private-running-journal/backend/application/trackEditor"
+	"github.com/fafeitsch/private-running-journal/backend/application/trashBin"
 	"github.com/fafeitsch/private-running-journal/backend/backup"
+	"github.com/fafeitsch/private-running-journal/backend/eventLog"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
 	"github.com/fafeitsch/private-running-journal/backend/httpapi"
 	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/routing"
 	"github.com/fafeitsch/private-running-journal/backend/settings"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"github.com/fafeitsch/private-running-journal/backend/trackMatcher"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+	"github.com/fafeitsch/private-running-journal/backend/weather"
 	"log"
 	"net/http"
 	"os"
@@ -20,29 +29,46 @@ import (
 	"time"
 )
 
+// number of parsed tracks kept in memory
+const trackCacheCapacity = 256
+
 type App struct {
+	bus                *shared.EventBus
 	ctx                context.Context
 	configDirectory    string
 	trackEditor        *trackEditor.TrackEditor
 	journalEditor      *journalEditor.JournalEditor
 	journalList        *journalList.JournalList
 	dashboardAssembler *dashboard.Assembler
+	calendar           *calendar.Calendar
+	duplicateFinder    *duplicateFinder.DuplicateFinder
+	trashBin           *trashBin.TrashBin
 	settings           *settings.Settings
 	backup             *backup.Backup
 	cache              *projection.Projection
+	eventLog           *eventLog.EventLog
+	undo               *undo.Stack
 	trackTree          *projection.TrackTree
+	searchIndex        *projection.SearchIndex
+	trackUsages        *projection.TrackUsages
+	trackLocations     *projection.TrackLocations
+	sqliteIndex        *projection.SqliteIndex
+	fileService        *filebased.Service
+	tileServer         *httpapi.TileServer
+	staticMapRenderer  *httpapi.StaticMapRenderer
+	heatmapServer      *httpapi.HeatmapServer
 }
 
 func NewApp() *App {
-	a := &App{}
+	a := &App{bus: shared.NewEventBus()}
 	a.setupConfigDirectory()
 	var err error
-	a.settings, err = settings.New(a.configDirectory)
+	a.settings, err = settings.New(a.bus, a.configDirectory)
 	if err != nil {
 		log.Fatalf("could not read settings: %v", err)
 	}
 	a.backup = backup.Init(
-		a.configDirectory, a.settings.GitSettings().Enabled, a.settings.GitSettings().PushAfterCommit,
+		a.bus, a.configDirectory, a.settings.GitSettings().Enabled, a.settings.GitSettings().PushAfterCommit,
 	)
 	if a.settings.GitSettings().Enabled && a.settings.GitSettings().PullOnStartUp {
 		log.Printf("pulling")
@@ -53,22 +79,75 @@ func NewApp() *App {
 	}
 
 	service := filebased.NewService(a.configDirectory)
-	err = service.Migrate()
+	a.fileService = service
+	tracks := trackCache.New(a.bus, service, trackCacheCapacity)
+	err = service.Migrate(a.bus)
 	if err != nil {
 		log.Fatalf("could not migrate: %v", err)
 	}
+	a.eventLog, err = eventLog.Open(a.bus, a.configDirectory, service)
+	if err != nil {
+		log.Fatalf("could not open event log: %v", err)
+	}
+	// the states kept for undoing changes must not end up in the backup of the config directory
+	undoDirectory, err := os.MkdirTemp("", "private-running-journal-undo-")
+	if err != nil {
+		log.Fatalf("could not create directory for undoing changes: %v", err)
+	}
+	a.undo = undo.New(a.bus, service, undoDirectory)
 	trackUsagesProjector := &projection.TrackUsages{}
-	sortedJournalProjector := &projection.SortedJournalEntries{Directory: a.configDirectory}
+	a.trackUsages = trackUsagesProjector
+	sortedJournalProjector := &projection.SortedJournalEntries{}
 	a.trackTree = &projection.TrackTree{}
-	a.journalEditor = journalEditor.New(service)
-	a.trackEditor = trackEditor.New(service, trackUsagesProjector)
-	a.journalList = journalList.New(service, sortedJournalProjector)
-	a.dashboardAssembler = dashboard.NewAssembler(sortedJournalProjector, service)
+	a.searchIndex = &projection.SearchIndex{}
+	weatherProvider := weather.NewProvider(
+		a.bus, a.settings.WeatherSettings().ProviderUrl, a.settings.WeatherSettings().FetchAutomatically,
+	)
+	matcher := trackMatcher.New(tracks)
+	a.journalEditor = journalEditor.New(service, weatherProvider, matcher, tracks, a.bus, a.undo)
+	trackLocationsProjector := &projection.TrackLocations{}
+	a.trackLocations = trackLocationsProjector
+	trackVariantsProjector := &projection.TrackVariants{}
+	trackStatisticsProjector := &projection.TrackStatistics{}
+	a.trackEditor = trackEditor.New(
+		service, trackUsagesProjector, trackLocationsProjector, trackVariantsProjector, trackStatisticsProjector,
+		routing.New(a.configDirectory), tracks, a.bus, a.undo,
+	)
+	var sqliteIndex *projection.SqliteIndex
+	if a.settings.AppSettings().SqliteIndex {
+		sqliteIndex = &projection.SqliteIndex{Directory: a.configDirectory}
+		a.sqliteIndex = sqliteIndex
+	}
+	a.journalList = journalList.New(service, sortedJournalProjector, sqliteIndex, tracks)
+	a.dashboardAssembler = dashboard.NewAssembler(
+		sortedJournalProjector, service, trackVariantsProjector, sqliteIndex, tracks,
+	)
+	a.calendar = calendar.New(sortedJournalProjector, service, tracks)
+	a.duplicateFinder = duplicateFinder.New(
+		service, trackUsagesProjector, trackVariantsProjector, tracks, a.bus, a.undo,
+	)
+	a.trashBin = trashBin.New(service, a.bus, a.settings.AppSettings().TrashRetentionDays)
 	projectors := make([]projection.Projector, 0)
 	projectors = append(projectors, trackUsagesProjector)
 	projectors = append(projectors, a.trackTree)
 	projectors = append(projectors, sortedJournalProjector)
-	a.cache = projection.New(filepath.Join(a.configDirectory, ".projection"), service, projectors...)
+	projectors = append(projectors, a.searchIndex)
+	projectors = append(projectors, matcher)
+	projectors = append(projectors, trackLocationsProjector)
+	projectors = append(projectors, trackVariantsProjector)
+	projectors = append(projectors, trackStatisticsProjector)
+	if sqliteIndex != nil {
+		projectors = append(projectors, sqliteIndex)
+	}
+	a.tileServer = httpapi.NewTileServer(
+		a.bus, a.configDirectory, a.settings.MapSettings().TileServer, a.settings.MapSettings().CacheTiles,
+	)
+	a.staticMapRenderer = httpapi.NewStaticMapRenderer(
+		a.tileServer, tracks, func() string {
+			return a.settings.MapSettings().Attribution
+		},
+	)
+	a.cache = projection.New(a.bus, filepath.Join(a.configDirectory, ".projection"), service, projectors...)
 	err = a.cache.Build()
 	if err != nil {
 		log.Fatalf("could not initialize projections: %v", err)
@@ -89,11 +168,23 @@ func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
 	shared.Context = ctx
 	var err error
-	tileServer := httpapi.NewTileServer(
-		a.configDirectory, a.settings.MapSettings().TileServer, a.settings.MapSettings().CacheTiles,
-	)
+	mux := http.NewServeMux()
+	mux.Handle("/tiles/", a.tileServer)
+	mux.Handle("/static-map/", a.staticMapRenderer)
+	mux.Handle("/attachments/", httpapi.NewAttachmentServer(a.fileService))
+	// the cached heatmap tiles must not end up in the backup of the config directory
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		cacheDirectory = ""
+	}
+	heatmapDirectory, err := os.MkdirTemp(cacheDirectory, "private-running-journal-heatmap-")
+	if err != nil {
+		log.Fatalf("could not create directory for heatmap tiles: %v", err)
+	}
+	a.heatmapServer = httpapi.NewHeatmapServer(a.bus, heatmapDirectory, a.trackLocations, a.trackUsages)
+	mux.Handle("/heatmap/", a.heatmapServer)
 	go func() {
-		err = http.ListenAndServe("127.0.0.1:47836", tileServer)
+		err = http.ListenAndServe("127.0.0.1:47836", mux)
 		if err != nil {
 			log.Fatalf("could not start tile server: %v", err)
 		}
@@ -102,6 +193,30 @@ func (a *App) Startup(ctx context.Context) {
 	log.Printf("start up")
 }
 
+func (a *App) Shutdown(ctx context.Context) {
+	a.bus.Wait()
+	err := a.cache.SaveCheckpoint()
+	if err != nil {
+		log.Printf("could not save projection checkpoint: %v", err)
+	}
+	if a.sqliteIndex != nil {
+		err = a.sqliteIndex.Close()
+		if err != nil {
+			log.Printf("could not close sqlite index: %v", err)
+		}
+	}
+	err = a.undo.Close()
+	if err != nil {
+		log.Printf("could not remove states for undoing changes: %v", err)
+	}
+	if a.heatmapServer != nil {
+		err = a.heatmapServer.Close()
+		if err != nil {
+			log.Printf("could not remove heatmap tiles: %v", err)
+		}
+	}
+}
+
 func (a *App) setupConfigDirectory() {
 	var homeDir, homeDirErr = os.UserHomeDir()
 	log.Printf("program args: %v", os.Args)
@@ -139,10 +254,54 @@ func (a *App) GetJournalListEntries(start string, end string) ([]journalList.Lis
 	return a.journalList.ReadListEntries(startDate, endDate)
 }
 
+func (a *App) QueryJournal(options journalList.QueryDto) (journalList.QueryResultDto, error) {
+	return a.journalList.Query(options)
+}
+
 func (a *App) GetTrackTree() projection.TrackTreeNode {
 	return a.trackTree.Get()
 }
 
+func (a *App) Search(query string) []projection.SearchHit {
+	return a.searchIndex.Search(query)
+}
+
+// VerifyProjections compares all projections with a fresh rebuild and repairs the inconsistent ones
+func (a *App) VerifyProjections() ([]projection.Report, error) {
+	return a.cache.Verify()
+}
+
+func (a *App) GetHistory(id string) ([]eventLog.HistoryEntry, error) {
+	return a.eventLog.History(id)
+}
+
+// ReplayEventLog rebuilds all projections from the event log instead of the files
+func (a *App) ReplayEventLog() error {
+	tracks, entries, err := a.eventLog.Replay()
+	if err != nil {
+		return err
+	}
+	a.cache.Replay(tracks, entries)
+	return nil
+}
+
+// Undo reverts the last save or deletion of a track or journal entry
+func (a *App) Undo() (undo.State, error) {
+	return a.undo.Undo()
+}
+
+func (a *App) Redo() (undo.State, error) {
+	return a.undo.Redo()
+}
+
+func (a *App) GetUndoState() undo.State {
+	return a.undo.State()
+}
+
+func (a *App) RenderTrackImage(id string, width int, height int) ([]byte, error) {
+	return a.staticMapRenderer.RenderTrack(id, width, height)
+}
+
 func (a *App) TrackEditor() *trackEditor.TrackEditor {
 	return a.trackEditor
 }
@@ -162,3 +321,15 @@ func (a *App) SaveSettings(settings settings.AppSettings) error {
 func (a *App) DashboardAssembler() *dashboard.Assembler {
 	return a.dashboardAssembler
 }
+
+func (a *App) Calendar() *calendar.Calendar {
+	return a.calendar
+}
+
+func (a *App) DuplicateFinder() *duplicateFinder.DuplicateFinder {
+	return a.duplicateFinder
+}
+
+func (a *App) TrashBin() *trashBin.TrashBin {
+	return a.trashBin
+}
@@ -0,0 +1,126 @@
+package calendar
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/query"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"log"
+	"time"
+)
+
+type Calendar struct {
+	sortedEntries *projection.SortedJournalEntries
+	fileService   *filebased.Service
+	tracks        *trackCache.Cache
+	now           func() time.Time
+}
+
+type GridDto struct {
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Weeks         []WeekDto `json:"weeks"`
+	TotalRuns     int       `json:"totalRuns"`
+	TotalDistance int       `json:"totalDistance"`
+}
+
+type WeekDto struct {
+	Year          int      `json:"year"`
+	Week          int      `json:"week"`
+	Days          []DayDto `json:"days"`
+	TotalRuns     int      `json:"totalRuns"`
+	TotalDistance int      `json:"totalDistance"`
+}
+
+// DayDto describes a day of the grid. Days outside the requested month or year are included to fill the
+// first and last week, but they are marked with InRange = false and contain no runs.
+// Entries dated after today count as planned, all others as done.
+type DayDto struct {
+	Date          string   `json:"date"`
+	InRange       bool     `json:"inRange"`
+	Runs          int      `json:"runs"`
+	TotalDistance int      `json:"totalDistance"`
+	Planned       int      `json:"planned"`
+	Done          int      `json:"done"`
+	EntryIds      []string `json:"entryIds"`
+}
+
+func New(
+	sortedEntries *projection.SortedJournalEntries, fileService *filebased.Service, tracks *trackCache.Cache,
+) *Calendar {
+	return &Calendar{sortedEntries: sortedEntries, fileService: fileService, tracks: tracks, now: time.Now}
+}
+
+func (c *Calendar) GetMonth(year int, month int) (GridDto, error) {
+	if month < 1 || month > 12 {
+		return GridDto{}, fmt.Errorf("invalid month %d", month)
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	return c.buildGrid(start, start.AddDate(0, 1, 0))
+}
+
+func (c *Calendar) GetYear(year int) (GridDto, error) {
+	start := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	return c.buildGrid(start, start.AddDate(1, 0, 0))
+}
+
+func (c *Calendar) buildGrid(start time.Time, end time.Time) (GridDto, error) {
+	ids, err := c.sortedEntries.FindJournalEntryIdsBetween(start, end)
+	if err != nil {
+		return GridDto{}, fmt.Errorf("error reading journal entries: %v", err)
+	}
+	entries := make([]shared.JournalEntry, 0, len(ids))
+	for _, id := range ids {
+		entry, err := c.fileService.ReadJournalEntry(id)
+		if err != nil {
+			log.Printf("could not read journal entry with id \"%s\": %v", id, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	candidatesPerDay := make(map[string][]query.Candidate)
+	for _, candidate := range query.Candidates(entries, c.tracks) {
+		day := candidate.Entry.Date.Format(time.DateOnly)
+		candidatesPerDay[day] = append(candidatesPerDay[day], candidate)
+	}
+	now := c.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	result := GridDto{
+		From:  start.Format(time.DateOnly),
+		To:    end.AddDate(0, 0, -1).Format(time.DateOnly),
+		Weeks: make([]WeekDto, 0),
+	}
+	// weeks start on Monday
+	day := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	for day.Before(end) {
+		year, week := day.ISOWeek()
+		weekDto := WeekDto{Year: year, Week: week, Days: make([]DayDto, 0, 7)}
+		for i := 0; i < 7; i++ {
+			dayDto := DayDto{
+				Date:     day.Format(time.DateOnly),
+				InRange:  !day.Before(start) && day.Before(end),
+				EntryIds: make([]string, 0),
+			}
+			for _, candidate := range candidatesPerDay[dayDto.Date] {
+				dayDto.Runs++
+				dayDto.TotalDistance = dayDto.TotalDistance + candidate.Length
+				dayDto.EntryIds = append(dayDto.EntryIds, candidate.Entry.Id)
+				if candidate.Entry.Date.After(today) {
+					dayDto.Planned++
+				} else {
+					dayDto.Done++
+				}
+			}
+			weekDto.TotalRuns = weekDto.TotalRuns + dayDto.Runs
+			weekDto.TotalDistance = weekDto.TotalDistance + dayDto.TotalDistance
+			weekDto.Days = append(weekDto.Days, dayDto)
+			day = day.AddDate(0, 0, 1)
+		}
+		result.TotalRuns = result.TotalRuns + weekDto.TotalRuns
+		result.TotalDistance = result.TotalDistance + weekDto.TotalDistance
+		result.Weeks = append(result.Weeks, weekDto)
+	}
+	return result, nil
+}
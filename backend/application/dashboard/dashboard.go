@@ -1,9 +1,10 @@
 package dashboard
 
 import (
+	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
 	"github.com/fafeitsch/private-running-journal/backend/projection"
-	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
 	"github.com/labstack/gommon/log"
 	"math"
 	"slices"
@@ -15,10 +16,20 @@ import (
 type DashboardDto struct {
 	TotalDistance    int                `json:"totalDistance"`
 	TopTracks        []Track            `json:"topTracks"`
+	TopFamilies      []TrackFamily      `json:"topFamilies"`
 	TotalRuns        int                `json:"totalRuns"`
 	MedianDistance   int                `json:"medianDistance"`
 	AverageDistance  int                `json:"averageDistance"`
 	MonthlyAnalytics []MonthlyAnalytics `json:"analytics"`
+	TemperatureBands []TemperatureBand  `json:"temperatureBands"`
+}
+
+type TemperatureBand struct {
+	From            int `json:"from"`
+	To              int `json:"to"`
+	TotalRuns       int `json:"totalRuns"`
+	AverageDistance int `json:"averageDistance"`
+	AveragePace     int `json:"averagePace"`
 }
 
 type Track struct {
@@ -29,6 +40,14 @@ type Track struct {
 	Length  int      `json:"length"`
 }
 
+// TrackFamily aggregates the runs on a track and on all of its variants
+type TrackFamily struct {
+	Id       string  `json:"id"`
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	Variants []Track `json:"variants"`
+}
+
 type MonthlyAnalytics struct {
 	Month           int `json:"month"`
 	Year            int `json:"year"`
@@ -41,10 +60,20 @@ type MonthlyAnalytics struct {
 type Assembler struct {
 	sortedEntries *projection.SortedJournalEntries
 	fileService   *filebased.Service
+	trackVariants *projection.TrackVariants
+	sqliteIndex   *projection.SqliteIndex
+	tracks        *trackCache.Cache
 }
 
-func NewAssembler(sortedEntries *projection.SortedJournalEntries, fileService *filebased.Service) *Assembler {
-	return &Assembler{sortedEntries: sortedEntries, fileService: fileService}
+// NewAssembler creates the dashboard assembler; sqliteIndex is optional and, if given, is queried instead of the files
+func NewAssembler(
+	sortedEntries *projection.SortedJournalEntries, fileService *filebased.Service,
+	trackVariants *projection.TrackVariants, sqliteIndex *projection.SqliteIndex, tracks *trackCache.Cache,
+) *Assembler {
+	return &Assembler{
+		sortedEntries: sortedEntries, fileService: fileService, trackVariants: trackVariants, sqliteIndex: sqliteIndex,
+		tracks: tracks,
+	}
 }
 
 type Options struct {
@@ -54,11 +83,15 @@ type Options struct {
 }
 
 type entry struct {
-	id     string
-	length int
-	date   time.Time
+	id          string
+	length      int
+	date        time.Time
+	duration    time.Duration
+	temperature *float64
 }
 
+const temperatureBandWidth = 5
+
 func (a *Assembler) LoadDashboard(options Options) (*DashboardDto, error) {
 	runsPerDay, tracks, err := a.readRunsPerDay(options)
 	if err != nil {
@@ -89,11 +122,13 @@ func (a *Assembler) LoadDashboard(options Options) (*DashboardDto, error) {
 				Name:    track.Name,
 				Count:   trackCounter[id],
 				Parents: track.Parents,
-				Length:  track.Waypoints.Length(),
+				Length:  track.Length,
 			},
 		)
 	}
+	topFamilies := a.createFamilies(topTracks)
 	monthlyAnalytics := createAnalytics(entryPerMonth)
+	temperatureBands := createTemperatureBands(runsPerDay)
 	slices.SortFunc(topTracks, compareTracks)
 	sum := 0
 	slices.Sort(lengths)
@@ -112,17 +147,14 @@ func (a *Assembler) LoadDashboard(options Options) (*DashboardDto, error) {
 		MedianDistance:   median,
 		AverageDistance:  average,
 		TopTracks:        topTracks[:int(math.Min(float64(options.TopTracks), float64(len(topTracks))))],
+		TopFamilies:      topFamilies[:min(options.TopTracks, len(topFamilies))],
 		TotalRuns:        len(lengths),
 		MonthlyAnalytics: monthlyAnalytics,
+		TemperatureBands: temperatureBands,
 	}, nil
 }
 
-func (a *Assembler) readRunsPerDay(options Options) (map[string][]entry, map[string]shared.Track, error) {
-	entries, err := a.sortedEntries.FindJournalEntryIdsBetween(options.From, options.To)
-	if err != nil {
-		return nil, nil, err
-	}
-	trackCache := make(map[string]shared.Track)
+func (a *Assembler) readRunsPerDay(options Options) (map[string][]entry, map[string]projection.IndexedTrack, error) {
 	entryPerDay := make(map[string][]entry)
 	currentMonth := options.From.AddDate(0, 0, -options.From.Day()+1)
 	lastOfToMonth := options.To.AddDate(0, 1, -options.To.Day())
@@ -130,35 +162,116 @@ func (a *Assembler) readRunsPerDay(options Options) (map[string][]entry, map[str
 		entryPerDay[currentMonth.Format(time.DateOnly)] = make([]entry, 0)
 		currentMonth = currentMonth.AddDate(0, 1, 0)
 	}
-	lengths := make([]int, 0, 0)
+	if a.sqliteIndex != nil {
+		return a.queryRunsPerDay(options, entryPerDay)
+	}
+	entries, err := a.sortedEntries.FindJournalEntryIdsBetween(options.From, options.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	tracks := make(map[string]projection.IndexedTrack)
 	for _, entryId := range entries {
 		loaded, err := a.fileService.ReadJournalEntry(entryId)
 		if err != nil {
 			return nil, nil, err
 		}
-		track, ok := trackCache[loaded.TrackId]
-		if !ok {
-			track, err = a.fileService.ReadTrack(loaded.TrackId)
+		track, ok := tracks[loaded.TrackId]
+		if loaded.EmbeddedTrack != nil {
+			// embedded tracks do not count as used tracks
+			track = projection.IndexedTrack{Length: loaded.EmbeddedTrack.Waypoints.Length()}
+		} else if !ok {
+			cached, err := a.tracks.Get(loaded.TrackId)
 			if err != nil {
 				return nil, nil, err
 			}
-			trackCache[loaded.TrackId] = track
+			track = projection.IndexedTrack{Name: cached.Name, Parents: cached.Parents, Length: cached.Length}
+			tracks[loaded.TrackId] = track
 		}
-		length := track.Waypoints.Length() * loaded.Laps
+		length := track.Length * loaded.Laps
 		if loaded.CustomLength != nil {
 			length = *loaded.CustomLength
 		}
-		lengths = append(lengths, length)
-		month := loaded.Date.Format(time.DateOnly)
-		entryPerDay[month] = append(
-			entryPerDay[month], entry{
-				id:     loaded.TrackId,
-				length: length,
-				date:   loaded.Date,
+		var temperature *float64
+		if loaded.Weather != nil {
+			temperature = &loaded.Weather.Temperature
+		}
+		duration, _ := loaded.Duration()
+		day := loaded.Date.Format(time.DateOnly)
+		entryPerDay[day] = append(
+			entryPerDay[day], entry{
+				id:          loaded.TrackId,
+				length:      length,
+				date:        loaded.Date,
+				duration:    duration,
+				temperature: temperature,
+			},
+		)
+	}
+	return entryPerDay, tracks, nil
+}
+
+func (a *Assembler) queryRunsPerDay(options Options, entryPerDay map[string][]entry) (
+	map[string][]entry,
+	map[string]projection.IndexedTrack,
+	error,
+) {
+	entries, err := a.sqliteIndex.FindEntriesBetween(options.From, options.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	trackIds := make([]string, 0)
+	for _, indexed := range entries {
+		if indexed.TrackMissing && indexed.TrackId != "" {
+			return nil, nil, fmt.Errorf("could not find track %s of journal entry %s", indexed.TrackId, indexed.Id)
+		}
+		if indexed.TrackId != "" && !slices.Contains(trackIds, indexed.TrackId) {
+			trackIds = append(trackIds, indexed.TrackId)
+		}
+		day := indexed.Date.Format(time.DateOnly)
+		entryPerDay[day] = append(
+			entryPerDay[day], entry{
+				id:          indexed.TrackId,
+				length:      indexed.Length,
+				date:        indexed.Date,
+				duration:    time.Duration(indexed.Duration) * time.Second,
+				temperature: indexed.Temperature,
 			},
 		)
 	}
-	return entryPerDay, trackCache, nil
+	tracks, err := a.sqliteIndex.FindTracks(trackIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entryPerDay, tracks, nil
+}
+
+// createFamilies groups the used tracks by their family root; the variants of a family are sorted by usage
+func (a *Assembler) createFamilies(tracks []Track) []TrackFamily {
+	families := make(map[string]*TrackFamily)
+	for _, track := range tracks {
+		root := a.trackVariants.Root(track.Id)
+		family, ok := families[root]
+		if !ok {
+			family = &TrackFamily{Id: root, Name: a.trackVariants.Name(root), Variants: make([]Track, 0)}
+			families[root] = family
+		}
+		family.Count = family.Count + track.Count
+		family.Variants = append(family.Variants, track)
+	}
+	result := make([]TrackFamily, 0, len(families))
+	for _, family := range families {
+		slices.SortFunc(family.Variants, compareTracks)
+		result = append(result, *family)
+	}
+	slices.SortFunc(
+		result, func(a, b TrackFamily) int {
+			if a.Count != b.Count {
+				return b.Count - a.Count
+			}
+			return strings.Compare(a.Name, b.Name)
+		},
+	)
+	return result
 }
 
 func createAnalytics(entries map[string][]int) []MonthlyAnalytics {
@@ -200,6 +313,51 @@ func createAnalytics(entries map[string][]int) []MonthlyAnalytics {
 	return result
 }
 
+func createTemperatureBands(runsPerDay map[string][]entry) []TemperatureBand {
+	runsPerBand := make(map[int][]entry)
+	for _, entries := range runsPerDay {
+		for _, entry := range entries {
+			if entry.temperature == nil {
+				continue
+			}
+			band := int(math.Floor(*entry.temperature/temperatureBandWidth)) * temperatureBandWidth
+			runsPerBand[band] = append(runsPerBand[band], entry)
+		}
+	}
+	result := make([]TemperatureBand, 0, len(runsPerBand))
+	for from, entries := range runsPerBand {
+		distance := 0
+		pacedDistance := 0
+		duration := time.Duration(0)
+		for _, entry := range entries {
+			distance = distance + entry.length
+			if entry.duration > 0 && entry.length > 0 {
+				pacedDistance = pacedDistance + entry.length
+				duration = duration + entry.duration
+			}
+		}
+		pace := 0
+		if pacedDistance > 0 {
+			pace = int(duration.Seconds() * 1000 / float64(pacedDistance))
+		}
+		result = append(
+			result, TemperatureBand{
+				From:            from,
+				To:              from + temperatureBandWidth,
+				TotalRuns:       len(entries),
+				AverageDistance: distance / len(entries),
+				AveragePace:     pace,
+			},
+		)
+	}
+	slices.SortFunc(
+		result, func(a, b TemperatureBand) int {
+			return a.From - b.From
+		},
+	)
+	return result
+}
+
 func compareTracks(track1 Track, track2 Track) int {
 	compare := track2.Count - track1.Count
 	if compare == 0 {
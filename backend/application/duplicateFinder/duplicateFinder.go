@@ -0,0 +1,331 @@
+package duplicateFinder
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+	"log"
+	"math"
+	"slices"
+	"strings"
+)
+
+// waypoints are densified to this spacing (in meters) before comparing them, otherwise tracks drawn with
+// few clicks would seem different from tracks drawn with many clicks
+const comparisonSpacing = 25
+
+// tracks whose lengths differ by more than this ratio are never considered duplicates
+const maxLengthDifference = 0.2
+
+type DuplicateFinder struct {
+	service       *filebased.Service
+	trackUsages   *projection.TrackUsages
+	trackVariants *projection.TrackVariants
+	tracks        *trackCache.Cache
+	bus           *shared.EventBus
+	undo          *undo.Stack
+}
+
+type OptionsDto struct {
+	Threshold       float64 `json:"threshold"`
+	IgnoreDirection bool    `json:"ignoreDirection"`
+}
+
+type ClusterDto struct {
+	Tracks    []TrackDto `json:"tracks"`
+	Pairs     []PairDto  `json:"pairs"`
+	KeepTrack string     `json:"keepTrack"`
+}
+
+type TrackDto struct {
+	Id      string   `json:"id"`
+	Name    string   `json:"name"`
+	Parents []string `json:"parents"`
+	Length  int      `json:"length"`
+	Usages  int      `json:"usages"`
+}
+
+type PairDto struct {
+	First    string  `json:"first"`
+	Second   string  `json:"second"`
+	Distance int     `json:"distance"`
+	Overlap  float64 `json:"overlap"`
+	Reversed bool    `json:"reversed"`
+}
+
+type candidate struct {
+	track       shared.Track
+	densified   shared.Waypoints
+	length      int
+	boundingBox shared.BoundingBox
+}
+
+func New(
+	service *filebased.Service, trackUsages *projection.TrackUsages, trackVariants *projection.TrackVariants,
+	tracks *trackCache.Cache, bus *shared.EventBus, undo *undo.Stack,
+) *DuplicateFinder {
+	return &DuplicateFinder{
+		service: service, trackUsages: trackUsages, trackVariants: trackVariants, tracks: tracks, bus: bus,
+		undo: undo,
+	}
+}
+
+// FindDuplicates compares all tracks pairwise by their discrete Fréchet distance. Two tracks are
+// duplicates if their distance is at most the threshold (in meters). Duplicates are grouped into clusters,
+// i.e. if A is a duplicate of B and B of C, then A, B, and C form one cluster.
+func (d *DuplicateFinder) FindDuplicates(options OptionsDto) ([]ClusterDto, error) {
+	if options.Threshold <= 0 {
+		return nil, fmt.Errorf("threshold must be positive, but is %f", options.Threshold)
+	}
+	ids, err := d.service.TrackIds()
+	if err != nil {
+		return nil, fmt.Errorf("could not read tracks: %v", err)
+	}
+	candidates := make([]candidate, 0, len(ids))
+	for _, id := range ids {
+		track, err := d.tracks.Get(id)
+		if err != nil {
+			log.Printf("skipping track %s when searching duplicates: %v", id, err)
+			continue
+		}
+		densified, err := track.Waypoints.Densify(comparisonSpacing)
+		if err != nil {
+			log.Printf("skipping track %s when searching duplicates: %v", id, err)
+			continue
+		}
+		candidates = append(
+			candidates, candidate{
+				track:       track.Track,
+				densified:   densified,
+				length:      track.Length,
+				boundingBox: track.BoundingBox.Expand(options.Threshold),
+			},
+		)
+	}
+	parents := make([]int, len(candidates))
+	for i := range parents {
+		parents[i] = i
+	}
+	pairs := make([][]PairDto, len(candidates))
+	for i := range candidates {
+		for j := i + 1; j < len(candidates); j++ {
+			pair, ok := compare(candidates[i], candidates[j], options)
+			if !ok {
+				continue
+			}
+			union(parents, i, j)
+			pairs[i] = append(pairs[i], pair)
+		}
+	}
+	clusterSizes := make(map[int]int)
+	for i := range candidates {
+		clusterSizes[find(parents, i)]++
+	}
+	clusters := make(map[int]*ClusterDto)
+	for i := range candidates {
+		root := find(parents, i)
+		if clusterSizes[root] < 2 {
+			continue
+		}
+		cluster, ok := clusters[root]
+		if !ok {
+			cluster = &ClusterDto{Tracks: make([]TrackDto, 0), Pairs: make([]PairDto, 0)}
+			clusters[root] = cluster
+		}
+		usages, _ := d.trackUsages.GetUsages(candidates[i].track.Id)
+		cluster.Tracks = append(
+			cluster.Tracks, TrackDto{
+				Id:      candidates[i].track.Id,
+				Name:    candidates[i].track.Name,
+				Parents: candidates[i].track.Parents,
+				Length:  candidates[i].length,
+				Usages:  len(usages),
+			},
+		)
+		cluster.Pairs = append(cluster.Pairs, pairs[i]...)
+	}
+	result := make([]ClusterDto, 0, len(clusters))
+	for _, cluster := range clusters {
+		slices.SortFunc(
+			cluster.Tracks, func(a, b TrackDto) int {
+				if a.Usages != b.Usages {
+					return b.Usages - a.Usages
+				}
+				return strings.Compare(a.Name, b.Name)
+			},
+		)
+		cluster.KeepTrack = cluster.Tracks[0].Id
+		result = append(result, *cluster)
+	}
+	slices.SortFunc(
+		result, func(a, b ClusterDto) int {
+			return strings.Compare(a.Tracks[0].Name, b.Tracks[0].Name)
+		},
+	)
+	return result, nil
+}
+
+func compare(a candidate, b candidate, options OptionsDto) (PairDto, bool) {
+	longer := math.Max(float64(a.length), float64(b.length))
+	if longer > 0 && math.Abs(float64(a.length-b.length))/longer > maxLengthDifference {
+		return PairDto{}, false
+	}
+	if !a.boundingBox.Intersects(b.boundingBox) {
+		return PairDto{}, false
+	}
+	distance := shared.FrechetDistance(a.densified, b.densified)
+	reversed := false
+	if options.IgnoreDirection {
+		reversedDistance := shared.FrechetDistance(a.densified, b.densified.Reverse())
+		if reversedDistance < distance {
+			distance = reversedDistance
+			reversed = true
+		}
+	}
+	if distance > options.Threshold {
+		return PairDto{}, false
+	}
+	overlap := math.Min(
+		a.densified.Coverage(b.track.Waypoints, options.Threshold),
+		b.densified.Coverage(a.track.Waypoints, options.Threshold),
+	)
+	return PairDto{
+		First:    a.track.Id,
+		Second:   b.track.Id,
+		Distance: int(distance),
+		Overlap:  overlap,
+		Reversed: reversed,
+	}, true
+}
+
+func find(parents []int, i int) int {
+	for parents[i] != i {
+		parents[i] = parents[parents[i]]
+		i = parents[i]
+	}
+	return i
+}
+
+func union(parents []int, i int, j int) {
+	parents[find(parents, j)] = find(parents, i)
+}
+
+// MergeDuplicates moves all journal entries of the duplicates to the kept track and deletes the duplicates;
+// the merge can be undone as a whole
+func (d *DuplicateFinder) MergeDuplicates(keepId string, duplicateIds []string) error {
+	keep, err := d.tracks.Get(keepId)
+	if err != nil {
+		return fmt.Errorf("could not read track to keep: %v", err)
+	}
+	duplicateIds = slices.DeleteFunc(
+		slices.Clone(duplicateIds), func(id string) bool {
+			return id == keepId
+		},
+	)
+	usages := make(map[string][]string, len(duplicateIds))
+	objects := make([]undo.Object, 0)
+	for _, duplicateId := range duplicateIds {
+		entryIds, err := d.trackUsages.GetUsages(duplicateId)
+		if err != nil {
+			return err
+		}
+		usages[duplicateId] = slices.Clone(entryIds)
+		for _, entryId := range entryIds {
+			objects = append(objects, undo.Saved(undo.JournalEntry, entryId))
+		}
+		for _, variantId := range d.trackVariants.Variants(duplicateId) {
+			if !slices.Contains(duplicateIds, variantId) {
+				objects = append(objects, undo.Saved(undo.Track, variantId))
+			}
+		}
+	}
+	for _, duplicateId := range duplicateIds {
+		objects = append(objects, undo.Deleted(undo.Track, duplicateId))
+	}
+	change, err := d.undo.Begin("merge duplicates into "+keep.Name, objects...)
+	if err != nil {
+		return err
+	}
+	defer change.Discard()
+	err = d.merge(keepId, duplicateIds, usages)
+	if err != nil {
+		revertErr := change.Revert()
+		if revertErr != nil {
+			return fmt.Errorf("%v; the partial merge could not be reverted: %v", err, revertErr)
+		}
+		return err
+	}
+	change.Commit()
+	return nil
+}
+
+func (d *DuplicateFinder) merge(keepId string, duplicateIds []string, usages map[string][]string) error {
+	for _, duplicateId := range duplicateIds {
+		for _, entryId := range usages[duplicateId] {
+			entry, err := d.service.ReadJournalEntry(entryId)
+			if err != nil {
+				return fmt.Errorf("could not read journal entry %s: %v", entryId, err)
+			}
+			oldDate := entry.Date
+			entry.TrackId = keepId
+			err = d.service.SaveJournalEntry(entry)
+			if err != nil {
+				return fmt.Errorf("could not save journal entry %s: %v", entryId, err)
+			}
+			err = d.bus.Send(
+				shared.JournalEntryUpsertedEvent{JournalEntry: &entry, OldTrackId: duplicateId, OldDate: &oldDate},
+			)
+			if err != nil {
+				return fmt.Errorf("could not process saved journal entry %s: %v", entryId, err)
+			}
+		}
+		err := d.moveVariants(duplicateId, keepId, duplicateIds)
+		if err != nil {
+			return err
+		}
+		err = d.service.DeleteTrackDirectory(duplicateId, "merged into track "+keepId)
+		if err != nil {
+			return fmt.Errorf("could not delete track directory: %v", err)
+		}
+		err = d.bus.Send(shared.TrackDeletedEvent{Id: duplicateId})
+		if err != nil {
+			return fmt.Errorf("could not process deleted track %s: %v", duplicateId, err)
+		}
+	}
+	return nil
+}
+
+// moveVariants lets the variants of the duplicate derive from the kept track. If the kept track is
+// a variant of the duplicate itself, it takes over the duplicate's origin. Variants that are merged
+// themselves are left unchanged.
+func (d *DuplicateFinder) moveVariants(duplicateId string, keepId string, duplicateIds []string) error {
+	for _, variantId := range d.trackVariants.Variants(duplicateId) {
+		if slices.Contains(duplicateIds, variantId) {
+			continue
+		}
+		variant, err := d.tracks.ReadTrack(variantId)
+		if err != nil {
+			return fmt.Errorf("could not read variant %s: %v", variantId, err)
+		}
+		origin := keepId
+		if variantId == keepId {
+			origin = d.trackVariants.Origin(duplicateId)
+		}
+		saveTrack := shared.SaveTrack{
+			Id: variant.Id, Name: variant.Name, Comment: variant.Comment, Parents: variant.Parents,
+			Waypoints: variant.Waypoints, DerivesFrom: origin,
+		}
+		err = d.service.SaveTrack(saveTrack)
+		if err != nil {
+			return fmt.Errorf("could not save variant %s: %v", variantId, err)
+		}
+		err = d.bus.Send(shared.TrackUpsertedEvent{SaveTrack: &saveTrack})
+		if err != nil {
+			return fmt.Errorf("could not process saved variant %s: %v", variantId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package journalEditor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/media"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// the exif segment of a JPEG image (at most 64 KiB) follows the start of the image, at most preceded by
+// a short JFIF segment
+const exifHeaderLimit = 128 * 1024
+
+type AttachmentDto struct {
+	Name          string          `json:"name"`
+	Size          int64           `json:"size"`
+	Url           string          `json:"url"`
+	ThumbnailUrl  string          `json:"thumbnailUrl"`
+	Width         int             `json:"width"`
+	Height        int             `json:"height"`
+	Coordinates   *CoordinatesDto `json:"coordinates"`
+	TrackDistance *int            `json:"trackDistance"`
+}
+
+type CoordinatesDto struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (j *JournalEditor) AddAttachment(entryId string, name string, content []byte) (AttachmentDto, error) {
+	entry, err := j.fileService.ReadJournalEntry(entryId)
+	if err != nil {
+		return AttachmentDto{}, fmt.Errorf("could not read journal entry: %v", err)
+	}
+	name, err = j.fileService.SaveAttachment(entryId, name, content)
+	if err != nil {
+		return AttachmentDto{}, fmt.Errorf("could not save attachment: %v", err)
+	}
+	attachment := shared.Attachment{EntryId: entryId, Name: name, Size: int64(len(content))}
+	if media.IsImage(http.DetectContentType(content)) {
+		thumbnail, err := media.Thumbnail(content)
+		if err == nil {
+			err = j.fileService.SaveThumbnail(entryId, name, thumbnail)
+			attachment.HasThumbnail = err == nil
+		}
+		if err != nil {
+			log.Printf("could not create thumbnail for attachment %s: %v", name, err)
+		}
+	}
+	err = j.bus.Send(shared.JournalAttachmentsChangedEvent{EntryId: entryId})
+	if err != nil {
+		return AttachmentDto{}, fmt.Errorf("could not process changed attachments: %v", err)
+	}
+	track, _ := j.tracks.OfEntry(entry)
+	return j.mapAttachmentToDto(attachment, track.Waypoints), nil
+}
+
+func (j *JournalEditor) GetAttachments(entryId string) ([]AttachmentDto, error) {
+	entry, err := j.fileService.ReadJournalEntry(entryId)
+	if err != nil {
+		return nil, fmt.Errorf("could not read journal entry: %v", err)
+	}
+	attachments, err := j.fileService.ReadAttachments(entryId)
+	if err != nil {
+		return nil, err
+	}
+	track, err := j.tracks.OfEntry(entry)
+	if err != nil {
+		log.Printf("could not read track of journal entry %s: %v", entryId, err)
+	}
+	result := make([]AttachmentDto, 0, len(attachments))
+	for _, attachment := range attachments {
+		result = append(result, j.mapAttachmentToDto(attachment, track.Waypoints))
+	}
+	return result, nil
+}
+
+func (j *JournalEditor) DeleteAttachment(entryId string, name string) error {
+	err := j.fileService.DeleteAttachment(entryId, name)
+	if err != nil {
+		return err
+	}
+	err = j.bus.Send(shared.JournalAttachmentsChangedEvent{EntryId: entryId})
+	if err != nil {
+		return fmt.Errorf("could not process changed attachments: %v", err)
+	}
+	return nil
+}
+
+func (j *JournalEditor) mapAttachmentToDto(attachment shared.Attachment, waypoints shared.Waypoints) AttachmentDto {
+	path := "/attachments/" + url.PathEscape(attachment.EntryId) + "/" + url.PathEscape(attachment.Name)
+	result := AttachmentDto{Name: attachment.Name, Size: attachment.Size, Url: path}
+	if attachment.HasThumbnail {
+		result.ThumbnailUrl = path + "?thumbnail"
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(attachment.Name)))
+	if !media.IsImage(mimeType) {
+		return result
+	}
+	file, err := j.fileService.OpenAttachment(attachment.EntryId, attachment.Name)
+	if err != nil {
+		log.Printf("could not read attachment %s: %v", attachment.Name, err)
+		return result
+	}
+	defer file.Close()
+	// the exif data precedes the image data, which is never read
+	header, err := io.ReadAll(io.LimitReader(file, exifHeaderLimit))
+	if err != nil {
+		log.Printf("could not read attachment %s: %v", attachment.Name, err)
+		return result
+	}
+	result.Width, result.Height, err = media.Dimensions(io.MultiReader(bytes.NewReader(header), file))
+	if err != nil {
+		log.Printf("could not read dimensions of attachment %s: %v", attachment.Name, err)
+	}
+	if mimeType != "image/jpeg" {
+		return result
+	}
+	coordinates, err := media.GpsCoordinates(header)
+	if err != nil {
+		log.Printf("could not read exif data of attachment %s: %v", attachment.Name, err)
+	}
+	if coordinates == nil {
+		return result
+	}
+	result.Coordinates = &CoordinatesDto{Latitude: coordinates.Latitude, Longitude: coordinates.Longitude}
+	if len(waypoints) > 0 {
+		distance, _ := waypoints.Locate(*coordinates)
+		result.TrackDistance = &distance
+	}
+	return result
+}
@@ -3,36 +3,65 @@ package journalEditor
 import (
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/markdown"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"github.com/fafeitsch/private-running-journal/backend/trackMatcher"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+	"github.com/fafeitsch/private-running-journal/backend/weather"
+	"log"
 	"time"
 )
 
 type JournalEditor struct {
-	fileService *filebased.Service
+	fileService     *filebased.Service
+	weatherProvider *weather.Provider
+	trackMatcher    *trackMatcher.Matcher
+	tracks          *trackCache.Cache
+	bus             *shared.EventBus
+	undo            *undo.Stack
+}
+
+type WeatherDto struct {
+	Temperature   float64 `json:"temperature"`
+	WindSpeed     float64 `json:"windSpeed"`
+	Precipitation float64 `json:"precipitation"`
+	Conditions    string  `json:"conditions"`
 }
 
 type SaveEntryDto struct {
-	Id           string `json:"id"`
-	TrackId      string `json:"trackId"`
-	Date         string `json:"date"`
-	Comment      string `json:"comment"`
-	Time         string `json:"time"`
-	Laps         int    `json:"laps"`
-	CustomLength *int   `json:"customLength"`
+	Id           string      `json:"id"`
+	TrackId      string      `json:"trackId"`
+	Date         string      `json:"date"`
+	Comment      string      `json:"comment"`
+	Time         string      `json:"time"`
+	Laps         int         `json:"laps"`
+	CustomLength *int        `json:"customLength"`
+	Weather      *WeatherDto `json:"weather"`
 }
 
 type EntryDto struct {
-	Id           string `json:"id"`
-	TrackId      string `json:"trackId"`
-	Date         string `json:"date"`
-	Comment      string `json:"comment"`
-	Time         string `json:"time"`
-	Laps         int    `json:"laps"`
-	CustomLength *int   `json:"customLength"`
+	Id           string      `json:"id"`
+	TrackId      string      `json:"trackId"`
+	Date         string      `json:"date"`
+	Comment      string      `json:"comment"`
+	CommentHtml  string      `json:"commentHtml"`
+	Time         string      `json:"time"`
+	Laps         int         `json:"laps"`
+	CustomLength *int        `json:"customLength"`
+	Weather      *WeatherDto `json:"weather"`
+	// EmbeddedTrackName is set if the entry's track was deleted and is embedded into the entry
+	EmbeddedTrackName string `json:"embeddedTrackName,omitempty"`
 }
 
-func New(service *filebased.Service) *JournalEditor {
-	return &JournalEditor{fileService: service}
+func New(
+	service *filebased.Service, weatherProvider *weather.Provider, trackMatcher *trackMatcher.Matcher,
+	tracks *trackCache.Cache, bus *shared.EventBus, undo *undo.Stack,
+) *JournalEditor {
+	return &JournalEditor{
+		fileService: service, weatherProvider: weatherProvider, trackMatcher: trackMatcher, tracks: tracks, bus: bus,
+		undo: undo,
+	}
 }
 
 type SaveJournalEntryResultDto struct {
@@ -44,15 +73,68 @@ func (j *JournalEditor) GetJournalEntry(id string) (EntryDto, error) {
 	if err != nil {
 		return EntryDto{}, fmt.Errorf("could not read journal entry: %v", err)
 	}
-	return EntryDto{
+	result := EntryDto{
 		Id:           existing.Id,
 		TrackId:      existing.TrackId,
 		Date:         existing.Date.Format(time.DateOnly),
 		Comment:      existing.Comment,
+		CommentHtml:  markdown.Render(existing.Comment),
 		Time:         existing.Time,
 		Laps:         existing.Laps,
 		CustomLength: existing.CustomLength,
-	}, nil
+		Weather:      mapWeatherToDto(existing.Weather),
+	}
+	if existing.EmbeddedTrack != nil {
+		result.EmbeddedTrackName = existing.EmbeddedTrack.Name
+	}
+	return result, nil
+}
+
+func mapWeatherToDto(weather *shared.Weather) *WeatherDto {
+	if weather == nil {
+		return nil
+	}
+	return &WeatherDto{
+		Temperature:   weather.Temperature,
+		WindSpeed:     weather.WindSpeed,
+		Precipitation: weather.Precipitation,
+		Conditions:    weather.Conditions,
+	}
+}
+
+func mapDtoToWeather(dto *WeatherDto) *shared.Weather {
+	if dto == nil {
+		return nil
+	}
+	return &shared.Weather{
+		Temperature:   dto.Temperature,
+		WindSpeed:     dto.WindSpeed,
+		Precipitation: dto.Precipitation,
+		Conditions:    dto.Conditions,
+	}
+}
+
+func (j *JournalEditor) FetchWeather(trackId string, date string) (WeatherDto, error) {
+	parsedDate, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return WeatherDto{}, fmt.Errorf("could not parse date: %v", err)
+	}
+	result, err := j.fetchWeather(trackId, parsedDate)
+	if err != nil {
+		return WeatherDto{}, err
+	}
+	return *mapWeatherToDto(&result), nil
+}
+
+func (j *JournalEditor) fetchWeather(trackId string, date time.Time) (shared.Weather, error) {
+	track, err := j.tracks.Get(trackId)
+	if err != nil {
+		return shared.Weather{}, fmt.Errorf("could not read track: %v", err)
+	}
+	if len(track.Waypoints) == 0 {
+		return shared.Weather{}, fmt.Errorf("track %s has no waypoints", trackId)
+	}
+	return j.weatherProvider.Fetch(track.Waypoints[0], date)
 }
 
 func (j *JournalEditor) SaveJournalEntry(entry SaveEntryDto) (SaveJournalEntryResultDto, error) {
@@ -72,31 +154,88 @@ func (j *JournalEditor) SaveJournalEntry(entry SaveEntryDto) (SaveJournalEntryRe
 		CustomLength: entry.CustomLength,
 		Laps:         entry.Laps,
 		Time:         entry.Time,
+		Weather:      mapDtoToWeather(entry.Weather),
+	}
+	if journalEntry.TrackId == "" {
+		// the entry keeps its embedded track until another track is chosen
+		journalEntry.EmbeddedTrack = existing.EmbeddedTrack
 	}
+	change, err := j.undo.Begin(
+		"save journal entry of "+entry.Date, undo.Saved(undo.JournalEntry, entry.Id),
+	)
+	if err != nil {
+		return SaveJournalEntryResultDto{}, err
+	}
+	defer change.Discard()
 	err = j.fileService.SaveJournalEntry(
 		journalEntry,
 	)
 	if err != nil {
 		return SaveJournalEntryResultDto{}, fmt.Errorf("could not write journal entry: %v", err)
 	}
-	shared.SendEvent(
+	change.Commit()
+	err = j.bus.Send(
 		shared.JournalEntryUpsertedEvent{
 			JournalEntry: &journalEntry,
 			OldTrackId:   oldTrackId,
 			OldDate:      oldDate,
 		},
 	)
+	if err != nil {
+		return SaveJournalEntryResultDto{}, fmt.Errorf("could not process saved journal entry: %v", err)
+	}
+	if oldDate == nil && journalEntry.Weather == nil && j.weatherProvider.AutoFetch() {
+		go j.autoFetchWeather(journalEntry)
+	}
 	return SaveJournalEntryResultDto{Id: entry.Id}, nil
 }
 
+// autoFetchWeather adds the weather to a newly created journal entry unless the entry has been changed
+// or deleted in the meantime
+func (j *JournalEditor) autoFetchWeather(created shared.JournalEntry) {
+	fetched, err := j.fetchWeather(created.TrackId, created.Date)
+	if err != nil {
+		log.Printf("could not fetch weather for journal entry %s: %v", created.Id, err)
+		return
+	}
+	entry, err := j.fileService.ReadJournalEntry(created.Id)
+	if err != nil || entry.Weather != nil || entry.TrackId != created.TrackId || !entry.Date.Equal(created.Date) {
+		return
+	}
+	entry.Weather = &fetched
+	err = j.fileService.SaveJournalEntry(entry)
+	if err != nil {
+		log.Printf("could not save weather of journal entry %s: %v", created.Id, err)
+		return
+	}
+	err = j.bus.Send(
+		shared.JournalEntryUpsertedEvent{JournalEntry: &entry, OldTrackId: entry.TrackId, OldDate: &entry.Date},
+	)
+	if err != nil {
+		log.Printf("could not process weather of journal entry %s: %v", created.Id, err)
+	}
+}
+
 func (j *JournalEditor) DeleteJournalEntry(id string) error {
 	existing, err := j.fileService.ReadJournalEntry(id)
 	if err != nil {
 		return err
 	}
-	err = j.fileService.DeleteJournalEntry(id)
-	if err == nil {
-		shared.SendEvent(shared.JournalEntryDeletedEvent{JournalEntry: &existing})
+	change, err := j.undo.Begin(
+		"delete journal entry of "+existing.Date.Format(time.DateOnly), undo.Deleted(undo.JournalEntry, id),
+	)
+	if err != nil {
+		return err
+	}
+	defer change.Discard()
+	err = j.fileService.DeleteJournalEntry(id, "deleted by user")
+	if err != nil {
+		return err
+	}
+	change.Commit()
+	err = j.bus.Send(shared.JournalEntryDeletedEvent{JournalEntry: &existing})
+	if err != nil {
+		return fmt.Errorf("could not process deleted journal entry: %v", err)
 	}
-	return err
+	return nil
 }
@@ -0,0 +1,59 @@
+package journalEditor
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+)
+
+const maxTrackMatches = 5
+
+type TrackMatchDto struct {
+	TrackId  string   `json:"trackId"`
+	Name     string   `json:"name"`
+	Parents  []string `json:"parents"`
+	Length   int      `json:"length"`
+	Overlap  float64  `json:"overlap"`
+	Coverage float64  `json:"coverage"`
+	Laps     int      `json:"laps"`
+	Score    float64  `json:"score"`
+}
+
+func (j *JournalEditor) MatchTrace(trace []CoordinatesDto) ([]TrackMatchDto, error) {
+	waypoints := make(shared.Waypoints, 0, len(trace))
+	for _, coordinates := range trace {
+		waypoints = append(waypoints, shared.Coordinates{Latitude: coordinates.Latitude, Longitude: coordinates.Longitude})
+	}
+	return j.matchWaypoints(waypoints)
+}
+
+func (j *JournalEditor) MatchGpx(content []byte) ([]TrackMatchDto, error) {
+	waypoints, err := filebased.ParseGpx(content)
+	if err != nil {
+		return nil, err
+	}
+	return j.matchWaypoints(waypoints)
+}
+
+func (j *JournalEditor) matchWaypoints(waypoints shared.Waypoints) ([]TrackMatchDto, error) {
+	matches, err := j.trackMatcher.Match(waypoints, maxTrackMatches)
+	if err != nil {
+		return nil, fmt.Errorf("could not match trace: %v", err)
+	}
+	result := make([]TrackMatchDto, 0, len(matches))
+	for _, match := range matches {
+		result = append(
+			result, TrackMatchDto{
+				TrackId:  match.Track.Id,
+				Name:     match.Track.Name,
+				Parents:  match.Track.Parents,
+				Length:   match.Track.Length,
+				Overlap:  match.Overlap,
+				Coverage: match.Coverage,
+				Laps:     match.Laps,
+				Score:    match.Score,
+			},
+		)
+	}
+	return result, nil
+}
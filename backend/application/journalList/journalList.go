@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
 	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/query"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
 	"log"
 	"time"
 )
@@ -12,12 +14,20 @@ import (
 type JournalList struct {
 	fileService            *filebased.Service
 	sortedJournalProjector *projection.SortedJournalEntries
+	sqliteIndex            *projection.SqliteIndex
+	tracks                 *trackCache.Cache
 }
 
-func New(service *filebased.Service, sortedJournalProjector *projection.SortedJournalEntries) *JournalList {
+// New creates the journal list; sqliteIndex is optional and, if given, is used instead of the files for listing entries
+func New(
+	service *filebased.Service, sortedJournalProjector *projection.SortedJournalEntries,
+	sqliteIndex *projection.SqliteIndex, tracks *trackCache.Cache,
+) *JournalList {
 	return &JournalList{
 		fileService:            service,
 		sortedJournalProjector: sortedJournalProjector,
+		sqliteIndex:            sqliteIndex,
+		tracks:                 tracks,
 	}
 }
 
@@ -30,35 +40,131 @@ type ListEntryDto struct {
 }
 
 func (j *JournalList) ReadListEntries(start time.Time, end time.Time) ([]ListEntryDto, error) {
+	if j.sqliteIndex != nil {
+		return j.readIndexedListEntries(start, end)
+	}
 	result := make([]ListEntryDto, 0)
 	ids, err := j.sortedJournalProjector.FindJournalEntryIdsBetween(start, end)
 	if err != nil {
 		return nil, fmt.Errorf("error reading journal entries: %v", err)
 	}
-	trackCache := make(map[string]shared.Track)
 	for _, journalId := range ids {
 		file, err := j.fileService.ReadJournalEntry(journalId)
 		if err != nil {
 			log.Printf("could not read journal entry with id \"%s\": %v", journalId, err)
 			continue
 		}
-		track, ok := trackCache[file.TrackId]
 		entry := ListEntryDto{Id: file.Id, Date: file.Date.Format(time.DateOnly)}
-		if !ok {
-			track, err = j.fileService.ReadTrack(file.TrackId)
-			if err != nil {
-				entry.TrackName = file.TrackId
-				entry.TrackError = true
-				log.Printf("could not read track of joural entry %s: %v", file.Id, err)
-			}
+		track, err := j.tracks.OfEntry(file)
+		if err != nil {
+			log.Printf("could not read track of joural entry %s: %v", file.Id, err)
 		}
-		entry.Length = track.Waypoints.Length() * file.Laps
+		entry.Length = track.Length * file.Laps
 		if file.CustomLength != nil {
 			entry.Length = *file.CustomLength
 		}
 		entry.TrackName = track.Name
-		trackCache[file.TrackId] = track
+		if err != nil {
+			entry.TrackName = file.TrackId
+			entry.TrackError = true
+		}
 		result = append(result, entry)
 	}
 	return result, nil
 }
+
+func (j *JournalList) readIndexedListEntries(start time.Time, end time.Time) ([]ListEntryDto, error) {
+	entries, err := j.sqliteIndex.FindEntriesBetween(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal entries: %v", err)
+	}
+	result := make([]ListEntryDto, 0, len(entries))
+	for _, entry := range entries {
+		dto := ListEntryDto{
+			Id:         entry.Id,
+			Date:       entry.Date.Format(time.DateOnly),
+			TrackName:  entry.TrackName,
+			TrackError: entry.TrackMissing,
+			Length:     entry.Length,
+		}
+		if entry.TrackMissing && entry.TrackId == "" {
+			// the track is embedded into the entry, which is not indexed
+			dto.TrackError = !j.readEmbeddedTrackName(&dto)
+		} else if entry.TrackMissing {
+			dto.TrackName = entry.TrackId
+		}
+		result = append(result, dto)
+	}
+	return result, nil
+}
+
+func (j *JournalList) readEmbeddedTrackName(dto *ListEntryDto) bool {
+	file, err := j.fileService.ReadJournalEntry(dto.Id)
+	if err != nil || file.EmbeddedTrack == nil {
+		log.Printf("could not read embedded track of journal entry %s: %v", dto.Id, err)
+		return false
+	}
+	dto.TrackName = file.EmbeddedTrack.Name
+	return true
+}
+
+type QueryDto struct {
+	Query    string `json:"query"`
+	Sort     string `json:"sort"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+}
+
+type QueryResultDto struct {
+	Entries []ListEntryDto `json:"entries"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+}
+
+func (j *JournalList) Query(options QueryDto) (QueryResultDto, error) {
+	parsed, err := query.Parse(options.Query)
+	if err != nil {
+		return QueryResultDto{}, fmt.Errorf("could not parse query: %v", err)
+	}
+	start, end := parsed.DateRange()
+	ids, err := j.sortedJournalProjector.FindJournalEntryIdsBetween(start, end)
+	if err != nil {
+		return QueryResultDto{}, fmt.Errorf("error reading journal entries: %v", err)
+	}
+	entries := make([]shared.JournalEntry, 0, len(ids))
+	for _, journalId := range ids {
+		entry, err := j.fileService.ReadJournalEntry(journalId)
+		if err != nil {
+			log.Printf("could not read journal entry with id \"%s\": %v", journalId, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	matches := make([]query.Candidate, 0)
+	for _, candidate := range query.Candidates(entries, j.tracks) {
+		if parsed.Matches(candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	order := options.Sort
+	if order == "" {
+		order = parsed.Order
+	}
+	err = query.Sort(matches, order)
+	if err != nil {
+		return QueryResultDto{}, err
+	}
+	result := QueryResultDto{Entries: make([]ListEntryDto, 0), Total: len(matches), Page: options.Page}
+	for _, candidate := range query.Page(matches, options.Page, options.PageSize) {
+		result.Entries = append(
+			result.Entries, ListEntryDto{
+				TrackName:  candidate.TrackName,
+				TrackError: candidate.TrackError,
+				Length:     candidate.Length,
+				Date:       candidate.Entry.Date.Format(time.DateOnly),
+				Id:         candidate.Entry.Id,
+			},
+		)
+	}
+	return result, nil
+}
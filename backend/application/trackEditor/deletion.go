@@ -0,0 +1,205 @@
+package trackEditor
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+	"slices"
+	"strings"
+	"time"
+)
+
+// policies for journal entries that still reference a track which is about to be deleted
+const (
+	RefuseWhenUsed = "refuse"
+	ReassignUsages = "reassign"
+	EmbedInUsages  = "embed"
+)
+
+type DeleteTrackOptionsDto struct {
+	// Policy is one of RefuseWhenUsed, ReassignUsages, or EmbedInUsages; RefuseWhenUsed if empty
+	Policy string `json:"policy"`
+	// ReassignTo is the track the journal entries reference instead if the policy is ReassignUsages
+	ReassignTo string `json:"reassignTo"`
+	// DryRun only reports what would change without changing anything
+	DryRun bool `json:"dryRun"`
+}
+
+// AffectedEntryDto describes how a journal entry referencing the deleted track is changed. If the track
+// is embedded into the entry, NewTrackId is empty and CustomLength is the length the entry keeps.
+type AffectedEntryDto struct {
+	Id           string `json:"id"`
+	Date         string `json:"date"`
+	NewTrackId   string `json:"newTrackId"`
+	CustomLength *int   `json:"customLength"`
+}
+
+type DeleteTrackReportDto struct {
+	TrackId          string             `json:"trackId"`
+	TrackName        string             `json:"trackName"`
+	Deleted          bool               `json:"deleted"`
+	AffectedEntries  []AffectedEntryDto `json:"affectedEntries"`
+	AffectedVariants []TrackVariant     `json:"affectedVariants"`
+	// ChangedEntries are the journal entries that stay changed although the deletion failed because their
+	// previous state could not be restored
+	ChangedEntries []string `json:"changedEntries"`
+}
+
+// DeleteTrack moves the track to the trash if it is not used by any journal entry; its variants derive
+// from the track's origin afterwards
+func (t *TrackEditor) DeleteTrack(id string) error {
+	_, err := t.deleteTrack(id, DeleteTrackOptionsDto{Policy: RefuseWhenUsed}, "deleted by user")
+	return err
+}
+
+// DeleteTrackWithPolicy moves the track to the trash and handles the journal entries still referencing
+// the track according to the policy. The report lists the changed journal entries and variants.
+func (t *TrackEditor) DeleteTrackWithPolicy(id string, options DeleteTrackOptionsDto) (DeleteTrackReportDto, error) {
+	return t.deleteTrack(id, options, "deleted by user")
+}
+
+func (t *TrackEditor) deleteTrack(id string, options DeleteTrackOptionsDto, reason string) (
+	DeleteTrackReportDto,
+	error,
+) {
+	track, err := t.tracks.ReadTrack(id)
+	if err != nil {
+		return DeleteTrackReportDto{}, fmt.Errorf("could not read track: %v", err)
+	}
+	usages, err := t.trackUsages.GetUsages(id)
+	if err != nil {
+		return DeleteTrackReportDto{}, err
+	}
+	usages = slices.Clone(usages)
+	report := DeleteTrackReportDto{
+		TrackId:          id,
+		TrackName:        track.Name,
+		AffectedEntries:  make([]AffectedEntryDto, 0, len(usages)),
+		AffectedVariants: make([]TrackVariant, 0),
+		ChangedEntries:   make([]string, 0),
+	}
+	origin := t.trackVariants.Origin(id)
+	variants := t.trackVariants.Variants(id)
+	for _, variantId := range variants {
+		report.AffectedVariants = append(
+			report.AffectedVariants, TrackVariant{Id: variantId, Name: t.trackVariants.Name(variantId)},
+		)
+	}
+	entries, err := t.updatedUsages(track, usages, options, &report)
+	if err != nil || options.DryRun {
+		return report, err
+	}
+	if len(entries) > 0 && (options.Policy == "" || options.Policy == RefuseWhenUsed) {
+		return report, fmt.Errorf("track \"%s\" is used by %d journal entries", track.Name, len(entries))
+	}
+
+	objects := make([]undo.Object, 0, len(entries)+len(variants)+1)
+	for _, entry := range entries {
+		objects = append(objects, undo.Saved(undo.JournalEntry, entry.Id))
+	}
+	for _, variantId := range variants {
+		objects = append(objects, undo.Saved(undo.Track, variantId))
+	}
+	change, err := t.undo.Begin("delete track "+track.Name, append(objects, undo.Deleted(undo.Track, id))...)
+	if err != nil {
+		return report, err
+	}
+	defer change.Discard()
+	changed := make([]string, 0, len(entries))
+	// fail restores the state before the deletion, the report lists the entries that cannot be restored
+	fail := func(err error) (DeleteTrackReportDto, error) {
+		revertErr := change.Revert()
+		if revertErr == nil || len(changed) == 0 {
+			return report, err
+		}
+		report.ChangedEntries = changed
+		return report, fmt.Errorf(
+			"%v; the already changed journal entries %s could not be restored: %v", err,
+			strings.Join(changed, ", "), revertErr,
+		)
+	}
+	for _, entry := range entries {
+		oldDate := entry.Date
+		err = t.service.SaveJournalEntry(entry)
+		if err != nil {
+			return fail(fmt.Errorf("could not save journal entry %s: %v", entry.Id, err))
+		}
+		changed = append(changed, entry.Id)
+		err = t.bus.Send(shared.JournalEntryUpsertedEvent{JournalEntry: &entry, OldTrackId: id, OldDate: &oldDate})
+		if err != nil {
+			return fail(fmt.Errorf("could not process saved journal entry %s: %v", entry.Id, err))
+		}
+	}
+	for _, variantId := range variants {
+		variant, err := t.tracks.ReadTrack(variantId)
+		if err != nil {
+			return fail(fmt.Errorf("could not read variant %s: %v", variantId, err))
+		}
+		err = t.saveTrack(
+			shared.SaveTrack{
+				Id: variant.Id, Name: variant.Name, Comment: variant.Comment, Parents: variant.Parents,
+				Waypoints: variant.Waypoints, DerivesFrom: origin,
+			},
+		)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	err = t.service.DeleteTrackDirectory(id, reason)
+	if err != nil {
+		return fail(fmt.Errorf("could not delete track directory: %v", err))
+	}
+	change.Commit()
+	report.Deleted = true
+	err = t.bus.Send(shared.TrackDeletedEvent{Id: id})
+	if err != nil {
+		return report, fmt.Errorf("could not process deleted track: %v", err)
+	}
+	return report, nil
+}
+
+// updatedUsages returns the journal entries referencing the track as they are after applying the policy
+// and adds them to the report; if the deletion is refused, the entries are returned unchanged
+func (t *TrackEditor) updatedUsages(
+	track shared.Track, usages []string, options DeleteTrackOptionsDto, report *DeleteTrackReportDto,
+) ([]shared.JournalEntry, error) {
+	if options.Policy == ReassignUsages {
+		if options.ReassignTo == track.Id {
+			return nil, fmt.Errorf("the journal entries cannot be reassigned to the deleted track")
+		}
+		_, err := t.tracks.Get(options.ReassignTo)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not read track %s to reassign the journal entries to: %v", options.ReassignTo, err,
+			)
+		}
+	} else if options.Policy != "" && options.Policy != RefuseWhenUsed && options.Policy != EmbedInUsages {
+		return nil, fmt.Errorf("unknown deletion policy \"%s\"", options.Policy)
+	}
+	result := make([]shared.JournalEntry, 0, len(usages))
+	length := track.Waypoints.Length()
+	for _, entryId := range usages {
+		entry, err := t.service.ReadJournalEntry(entryId)
+		if err != nil {
+			return nil, fmt.Errorf("could not read journal entry %s: %v", entryId, err)
+		}
+		if options.Policy == ReassignUsages {
+			entry.TrackId = options.ReassignTo
+		} else if options.Policy == EmbedInUsages {
+			if entry.CustomLength == nil {
+				customLength := length * entry.Laps
+				entry.CustomLength = &customLength
+			}
+			embedded := track
+			entry.EmbeddedTrack = &embedded
+			entry.TrackId = ""
+		}
+		result = append(result, entry)
+		affected := AffectedEntryDto{Id: entry.Id, Date: entry.Date.Format(time.DateOnly), NewTrackId: entry.TrackId}
+		if options.Policy != ReassignUsages {
+			affected.CustomLength = entry.CustomLength
+		}
+		report.AffectedEntries = append(report.AffectedEntries, affected)
+	}
+	return result, nil
+}
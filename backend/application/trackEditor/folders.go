@@ -0,0 +1,196 @@
+package trackEditor
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+	"slices"
+	"strings"
+)
+
+// RenameFolder renames the folder denoted by the path. If a sibling folder with the new name exists
+// already, both folders are merged.
+func (t *TrackEditor) RenameFolder(path []string, newName string) error {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return fmt.Errorf("the folder name must not be empty")
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("the root folder cannot be renamed")
+	}
+	return t.moveFolderContent(path, append(slices.Clone(path[:len(path)-1]), newName))
+}
+
+// MoveFolder moves the folder with all its tracks and sub folders into the new parent folder
+func (t *TrackEditor) MoveFolder(path []string, newParent []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("the root folder cannot be moved")
+	}
+	if isInFolder(newParent, path) {
+		return fmt.Errorf("a folder cannot be moved into itself")
+	}
+	return t.moveFolderContent(path, append(slices.Clone(newParent), path[len(path)-1]))
+}
+
+// MergeFolders moves the tracks and sub folders of the source folder into the target folder; the source
+// folder vanishes
+func (t *TrackEditor) MergeFolders(source []string, target []string) error {
+	if len(source) == 0 {
+		return fmt.Errorf("the root folder cannot be merged into another folder")
+	}
+	if isInFolder(target, source) {
+		return fmt.Errorf("a folder cannot be merged into itself or one of its sub folders")
+	}
+	return t.moveFolderContent(source, target)
+}
+
+// DeleteFolder deletes the folder with all its tracks and sub folders. The folder is only deleted
+// if none of its tracks is used by a journal entry. Variants outside the folder derive from the nearest
+// origin outside the folder afterwards.
+func (t *TrackEditor) DeleteFolder(path []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("the root folder cannot be deleted")
+	}
+	tracks, err := t.tracksInFolder(path)
+	if err != nil {
+		return err
+	}
+	deleted := make(map[string]shared.Track, len(tracks))
+	for _, track := range tracks {
+		usages, err := t.trackUsages.GetUsages(track.Id)
+		if err != nil {
+			return err
+		}
+		if len(usages) > 0 {
+			return fmt.Errorf("track \"%s\" is used by %d journal entries", track.Name, len(usages))
+		}
+		deleted[track.Id] = track
+	}
+	origins := make(map[string]string)
+	objects := make([]undo.Object, 0, len(tracks))
+	for _, track := range tracks {
+		for _, variantId := range t.trackVariants.Variants(track.Id) {
+			if _, ok := deleted[variantId]; ok {
+				continue
+			}
+			origin := track.DerivesFrom
+			for ancestor, ok := deleted[origin]; ok; ancestor, ok = deleted[origin] {
+				origin = ancestor.DerivesFrom
+			}
+			origins[variantId] = origin
+			objects = append(objects, undo.Saved(undo.Track, variantId))
+		}
+	}
+	for _, track := range tracks {
+		objects = append(objects, undo.Deleted(undo.Track, track.Id))
+	}
+	change, err := t.undo.Begin("delete folder "+strings.Join(path, "/"), objects...)
+	if err != nil {
+		return err
+	}
+	defer change.Discard()
+	err = t.deleteFolderTracks(path, tracks, origins)
+	if err != nil {
+		revertErr := change.Revert()
+		if revertErr != nil {
+			return fmt.Errorf("%v; the partially deleted folder could not be restored: %v", err, revertErr)
+		}
+		return err
+	}
+	change.Commit()
+	for _, track := range tracks {
+		err = t.bus.Send(shared.TrackDeletedEvent{Id: track.Id})
+		if err != nil {
+			return fmt.Errorf("could not process deleted track %s: %v", track.Id, err)
+		}
+	}
+	return nil
+}
+
+func (t *TrackEditor) deleteFolderTracks(path []string, tracks []shared.Track, origins map[string]string) error {
+	for variantId, origin := range origins {
+		variant, err := t.tracks.ReadTrack(variantId)
+		if err != nil {
+			return fmt.Errorf("could not read variant %s: %v", variantId, err)
+		}
+		err = t.saveTrack(
+			shared.SaveTrack{
+				Id: variant.Id, Name: variant.Name, Comment: variant.Comment, Parents: variant.Parents,
+				Waypoints: variant.Waypoints, DerivesFrom: origin,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, track := range tracks {
+		err := t.service.DeleteTrackDirectory(track.Id, "folder "+strings.Join(path, "/")+" deleted")
+		if err != nil {
+			return fmt.Errorf("could not delete track \"%s\": %v", track.Name, err)
+		}
+	}
+	return nil
+}
+
+// moveFolderContent replaces the path prefix of all tracks in the folder by the new path
+func (t *TrackEditor) moveFolderContent(path []string, newPath []string) error {
+	if slices.Equal(path, newPath) {
+		return nil
+	}
+	tracks, err := t.tracksInFolder(path)
+	if err != nil {
+		return err
+	}
+	parents := make(map[string][]string)
+	objects := make([]undo.Object, 0, len(tracks))
+	for _, track := range tracks {
+		parents[track.Id] = append(slices.Clone(newPath), track.Parents[len(path):]...)
+		objects = append(objects, undo.Saved(undo.Track, track.Id))
+	}
+	change, err := t.undo.Begin("move folder "+strings.Join(path, "/"), objects...)
+	if err != nil {
+		return err
+	}
+	defer change.Discard()
+	err = t.service.UpdateTrackParents(parents)
+	if err != nil {
+		return fmt.Errorf("could not move tracks: %v", err)
+	}
+	change.Commit()
+	for _, track := range tracks {
+		err = t.bus.Send(
+			shared.TrackUpsertedEvent{
+				SaveTrack: &shared.SaveTrack{
+					Id: track.Id, Name: track.Name, Comment: track.Comment, Parents: parents[track.Id],
+					Waypoints: track.Waypoints, DerivesFrom: track.DerivesFrom,
+				},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("could not process moved track %s: %v", track.Id, err)
+		}
+	}
+	return nil
+}
+
+func (t *TrackEditor) tracksInFolder(path []string) ([]shared.Track, error) {
+	result := make([]shared.Track, 0)
+	err := t.service.ReadAllTracks(
+		func(track shared.Track) {
+			if isInFolder(track.Parents, path) {
+				result = append(result, track)
+			}
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not read tracks: %v", err)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("folder \"%s\" does not exist", strings.Join(path, "/"))
+	}
+	return result, nil
+}
+
+func isInFolder(parents []string, folder []string) bool {
+	return len(parents) >= len(folder) && slices.Equal(parents[:len(folder)], folder)
+}
@@ -0,0 +1,165 @@
+package trackEditor
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
+)
+
+func (t *TrackEditor) ReverseTrack(id string) (TrackDto, error) {
+	return t.updateWaypoints(
+		id, func(waypoints shared.Waypoints) (shared.Waypoints, error) {
+			return waypoints.Reverse(), nil
+		},
+	)
+}
+
+func (t *TrackEditor) SimplifyTrack(id string, tolerance float64) (TrackDto, error) {
+	return t.updateWaypoints(
+		id, func(waypoints shared.Waypoints) (shared.Waypoints, error) {
+			if tolerance <= 0 {
+				return nil, fmt.Errorf("tolerance must be positive, but is %f", tolerance)
+			}
+			return waypoints.Simplify(tolerance), nil
+		},
+	)
+}
+
+func (t *TrackEditor) DensifyTrack(id string, spacing float64) (TrackDto, error) {
+	return t.updateWaypoints(
+		id, func(waypoints shared.Waypoints) (shared.Waypoints, error) {
+			return waypoints.Densify(spacing)
+		},
+	)
+}
+
+func (t *TrackEditor) CloseTrackLoop(id string) (TrackDto, error) {
+	return t.updateWaypoints(
+		id, func(waypoints shared.Waypoints) (shared.Waypoints, error) {
+			return waypoints.CloseLoop(), nil
+		},
+	)
+}
+
+// SplitTrack shortens the track to the waypoints up to the given index and creates a new track
+// with the remaining waypoints. Journal entries keep referencing the shortened track.
+func (t *TrackEditor) SplitTrack(id string, waypointIndex int) ([]TrackDto, error) {
+	track, err := t.tracks.ReadTrack(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read track: %v", err)
+	}
+	first, second, err := track.Waypoints.Split(waypointIndex)
+	if err != nil {
+		return nil, err
+	}
+	newTrack := shared.SaveTrack{
+		Id:        shared.UniqueId(),
+		Name:      track.Name + " (2)",
+		Comment:   track.Comment,
+		Parents:   track.Parents,
+		Waypoints: second,
+	}
+	change, err := t.undo.Begin(
+		"split track "+track.Name, undo.Saved(undo.Track, track.Id), undo.Saved(undo.Track, newTrack.Id),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer change.Discard()
+	err = t.saveTrack(
+		shared.SaveTrack{
+			Id: track.Id, Name: track.Name, Comment: track.Comment, Parents: track.Parents, Waypoints: first,
+			DerivesFrom: track.DerivesFrom,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = t.saveTrack(newTrack)
+	if err != nil {
+		return nil, err
+	}
+	change.Commit()
+	result := make([]TrackDto, 0, 2)
+	for _, trackId := range []string{track.Id, newTrack.Id} {
+		dto, err := t.GetTrack(trackId)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dto)
+	}
+	return result, nil
+}
+
+// ConcatTracks creates a new track consisting of the waypoints of all given tracks in the given order.
+// The new track is placed in the folder of the first track.
+func (t *TrackEditor) ConcatTracks(ids []string, name string) (TrackDto, error) {
+	if len(ids) < 2 {
+		return TrackDto{}, fmt.Errorf("at least two tracks are needed, but got %d", len(ids))
+	}
+	waypoints := make(shared.Waypoints, 0)
+	var parents []string
+	for _, id := range ids {
+		track, err := t.tracks.ReadTrack(id)
+		if err != nil {
+			return TrackDto{}, fmt.Errorf("could not read track %s: %v", id, err)
+		}
+		if parents == nil {
+			parents = track.Parents
+		}
+		waypoints = waypoints.Concat(track.Waypoints)
+	}
+	newTrack := shared.SaveTrack{Id: shared.UniqueId(), Name: name, Parents: parents, Waypoints: waypoints}
+	change, err := t.undo.Begin("concat tracks to "+name, undo.Saved(undo.Track, newTrack.Id))
+	if err != nil {
+		return TrackDto{}, err
+	}
+	defer change.Discard()
+	err = t.saveTrack(newTrack)
+	if err != nil {
+		return TrackDto{}, err
+	}
+	change.Commit()
+	return t.GetTrack(newTrack.Id)
+}
+
+func (t *TrackEditor) updateWaypoints(
+	id string, operation func(waypoints shared.Waypoints) (shared.Waypoints, error),
+) (TrackDto, error) {
+	track, err := t.tracks.ReadTrack(id)
+	if err != nil {
+		return TrackDto{}, fmt.Errorf("could not read track: %v", err)
+	}
+	waypoints, err := operation(track.Waypoints)
+	if err != nil {
+		return TrackDto{}, err
+	}
+	change, err := t.undo.Begin("edit waypoints of "+track.Name, undo.Saved(undo.Track, id))
+	if err != nil {
+		return TrackDto{}, err
+	}
+	defer change.Discard()
+	err = t.saveTrack(
+		shared.SaveTrack{
+			Id: track.Id, Name: track.Name, Comment: track.Comment, Parents: track.Parents, Waypoints: waypoints,
+			DerivesFrom: track.DerivesFrom,
+		},
+	)
+	if err != nil {
+		return TrackDto{}, err
+	}
+	change.Commit()
+	return t.GetTrack(id)
+}
+
+func (t *TrackEditor) saveTrack(track shared.SaveTrack) error {
+	err := t.service.SaveTrack(track)
+	if err != nil {
+		return fmt.Errorf("could not save track %s: %v", track.Id, err)
+	}
+	err = t.bus.Send(shared.TrackUpsertedEvent{SaveTrack: &track})
+	if err != nil {
+		return fmt.Errorf("could not process saved track %s: %v", track.Id, err)
+	}
+	return nil
+}
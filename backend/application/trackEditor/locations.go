@@ -0,0 +1,58 @@
+package trackEditor
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+)
+
+type BoundingBoxDto struct {
+	SouthWest CoordinateDto `json:"southWest"`
+	NorthEast CoordinateDto `json:"northEast"`
+}
+
+type TrackLocationDto struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Length   int    `json:"length"`
+	Distance int    `json:"distance"`
+}
+
+func (t *TrackEditor) FindTracksInArea(box BoundingBoxDto) []TrackLocationDto {
+	return mapTrackLocationsToDto(
+		t.trackLocations.FindInArea(
+			shared.BoundingBox{
+				MinLatitude:  box.SouthWest.Latitude,
+				MinLongitude: box.SouthWest.Longitude,
+				MaxLatitude:  box.NorthEast.Latitude,
+				MaxLongitude: box.NorthEast.Longitude,
+			},
+		),
+	)
+}
+
+func (t *TrackEditor) FindNearestTrack(coordinates CoordinateDto) (TrackLocationDto, error) {
+	result := t.trackLocations.FindNearest(
+		shared.Coordinates{Latitude: coordinates.Latitude, Longitude: coordinates.Longitude},
+	)
+	if result == nil {
+		return TrackLocationDto{}, fmt.Errorf("there is no track near the coordinates")
+	}
+	return TrackLocationDto(*result), nil
+}
+
+func (t *TrackEditor) FindTracksStartingNear(coordinates CoordinateDto, radius float64) []TrackLocationDto {
+	return mapTrackLocationsToDto(
+		t.trackLocations.FindStartingNear(
+			shared.Coordinates{Latitude: coordinates.Latitude, Longitude: coordinates.Longitude}, radius,
+		),
+	)
+}
+
+func mapTrackLocationsToDto(locations []projection.TrackLocation) []TrackLocationDto {
+	result := make([]TrackLocationDto, 0, len(locations))
+	for _, location := range locations {
+		result = append(result, TrackLocationDto(location))
+	}
+	return result
+}
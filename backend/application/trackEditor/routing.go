@@ -0,0 +1,28 @@
+package trackEditor
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+)
+
+// IsRoutingAvailable tells whether an OpenStreetMap extract (*.osm.pbf) is present in the config directory
+func (t *TrackEditor) IsRoutingAvailable() bool {
+	return t.router.Available()
+}
+
+// PlanRoute connects the clicked points along footpaths and roads. The result can be passed to
+// GetPolylineMeta and SaveTrack like manually set waypoints.
+func (t *TrackEditor) PlanRoute(points []CoordinateDto) ([]CoordinateDto, error) {
+	waypoints := make(shared.Waypoints, 0, len(points))
+	for _, point := range points {
+		waypoints = append(waypoints, shared.Coordinates{Latitude: point.Latitude, Longitude: point.Longitude})
+	}
+	route, err := t.router.Route(waypoints)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]CoordinateDto, 0, len(route))
+	for _, waypoint := range route {
+		result = append(result, CoordinateDto{Latitude: waypoint.Latitude, Longitude: waypoint.Longitude})
+	}
+	return result, nil
+}
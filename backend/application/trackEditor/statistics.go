@@ -0,0 +1,155 @@
+package trackEditor
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"time"
+)
+
+type TrackStatisticsDto struct {
+	TrackId   string  `json:"trackId"`
+	TotalRuns int     `json:"totalRuns"`
+	FirstRun  *string `json:"firstRun"`
+	LastRun   *string `json:"lastRun"`
+	// best and average times (in seconds) only consider runs covering the track exactly once
+	BestTime    int `json:"bestTime"`
+	AverageTime int `json:"averageTime"`
+	// paces are given in seconds per kilometer
+	BestPace    int              `json:"bestPace"`
+	AveragePace int              `json:"averagePace"`
+	PaceTrend   []PaceTrendPoint `json:"paceTrend"`
+	// the change of the pace per year according to a linear regression, negative values mean getting faster
+	PaceChangePerYear int          `json:"paceChangePerYear"`
+	RunsPerYear       []YearlyRuns `json:"runsPerYear"`
+	Runs              []TrackRun   `json:"runs"`
+}
+
+type PaceTrendPoint struct {
+	Year        int `json:"year"`
+	Month       int `json:"month"`
+	AveragePace int `json:"averagePace"`
+	Runs        int `json:"runs"`
+}
+
+type YearlyRuns struct {
+	Year          int `json:"year"`
+	Runs          int `json:"runs"`
+	TotalDistance int `json:"totalDistance"`
+}
+
+type TrackRun struct {
+	EntryId  string `json:"entryId"`
+	Date     string `json:"date"`
+	Duration int    `json:"duration"`
+	Laps     int    `json:"laps"`
+	Length   int    `json:"length"`
+	Pace     int    `json:"pace"`
+}
+
+func (t *TrackEditor) GetTrackStatistics(id string) TrackStatisticsDto {
+	runs := t.trackStatistics.Runs(id)
+	result := TrackStatisticsDto{
+		TrackId:     id,
+		TotalRuns:   len(runs),
+		PaceTrend:   make([]PaceTrendPoint, 0),
+		RunsPerYear: make([]YearlyRuns, 0),
+		Runs:        make([]TrackRun, 0, len(runs)),
+	}
+	if len(runs) == 0 {
+		return result
+	}
+	first, last := runs[0].Date.Format(time.DateOnly), runs[len(runs)-1].Date.Format(time.DateOnly)
+	result.FirstRun, result.LastRun = &first, &last
+	regularTime, regularRuns := 0, 0
+	pacedTime, pacedDistance := 0, 0
+	paced := make([]projection.TrackRun, 0)
+	for _, run := range runs {
+		pace := paceOf(run.Duration, run.Length)
+		result.Runs = append(
+			result.Runs, TrackRun{
+				EntryId:  run.EntryId,
+				Date:     run.Date.Format(time.DateOnly),
+				Duration: run.Duration,
+				Laps:     run.Laps,
+				Length:   run.Length,
+				Pace:     pace,
+			},
+		)
+		if len(result.RunsPerYear) == 0 || result.RunsPerYear[len(result.RunsPerYear)-1].Year != run.Date.Year() {
+			result.RunsPerYear = append(result.RunsPerYear, YearlyRuns{Year: run.Date.Year()})
+		}
+		year := &result.RunsPerYear[len(result.RunsPerYear)-1]
+		year.Runs = year.Runs + 1
+		year.TotalDistance = year.TotalDistance + run.Length
+		if run.Duration > 0 && run.Regular {
+			regularTime = regularTime + run.Duration
+			regularRuns = regularRuns + 1
+			if result.BestTime == 0 || run.Duration < result.BestTime {
+				result.BestTime = run.Duration
+			}
+		}
+		if pace == 0 {
+			continue
+		}
+		paced = append(paced, run)
+		pacedTime = pacedTime + run.Duration
+		pacedDistance = pacedDistance + run.Length
+		if result.BestPace == 0 || pace < result.BestPace {
+			result.BestPace = pace
+		}
+	}
+	if regularRuns > 0 {
+		result.AverageTime = regularTime / regularRuns
+	}
+	result.AveragePace = paceOf(pacedTime, pacedDistance)
+	result.PaceTrend = paceTrend(paced)
+	result.PaceChangePerYear = paceChangePerYear(paced)
+	return result
+}
+
+func paceOf(duration int, length int) int {
+	if duration <= 0 || length <= 0 {
+		return 0
+	}
+	return duration * 1000 / length
+}
+
+// paceTrend computes the average pace per month of the runs, which must be sorted by date
+func paceTrend(runs []projection.TrackRun) []PaceTrendPoint {
+	result := make([]PaceTrendPoint, 0)
+	duration, distance := 0, 0
+	for index, run := range runs {
+		duration = duration + run.Duration
+		distance = distance + run.Length
+		if len(result) == 0 || result[len(result)-1].Year != run.Date.Year() ||
+			result[len(result)-1].Month != int(run.Date.Month()) {
+			result = append(result, PaceTrendPoint{Year: run.Date.Year(), Month: int(run.Date.Month())})
+		}
+		point := &result[len(result)-1]
+		point.Runs = point.Runs + 1
+		next := index + 1
+		if next == len(runs) || runs[next].Date.Year() != point.Year || int(runs[next].Date.Month()) != point.Month {
+			point.AveragePace = paceOf(duration, distance)
+			duration, distance = 0, 0
+		}
+	}
+	return result
+}
+
+// paceChangePerYear fits a line through the paces of the runs by least squares and returns its slope
+func paceChangePerYear(runs []projection.TrackRun) int {
+	if len(runs) < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for _, run := range runs {
+		x := run.Date.Sub(runs[0].Date).Hours() / 24 / 365.25
+		y := float64(paceOf(run.Duration, run.Length))
+		sumX, sumY, sumXY, sumXX = sumX+x, sumY+y, sumXY+x*y, sumXX+x*x
+	}
+	n := float64(len(runs))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return int((n*sumXY - sumX*sumY) / denominator)
+}
@@ -3,8 +3,12 @@ package trackEditor
 import (
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/markdown"
 	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/routing"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"github.com/fafeitsch/private-running-journal/backend/undo"
 )
 
 type CoordinateDto struct {
@@ -19,12 +23,20 @@ type DistanceMarker struct {
 
 type TrackDto struct {
 	PolylineMeta
-	Id        string          `json:"id"`
-	Name      string          `json:"name"`
-	Waypoints []CoordinateDto `json:"waypoints"`
-	Parents   []string        `json:"parents"`
-	Usages    []string        `json:"usages"`
-	Comment   string          `json:"comment"`
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Waypoints   []CoordinateDto `json:"waypoints"`
+	Parents     []string        `json:"parents"`
+	Usages      []string        `json:"usages"`
+	Comment     string          `json:"comment"`
+	CommentHtml string          `json:"commentHtml"`
+	DerivesFrom string          `json:"derivesFrom"`
+	Variants    []TrackVariant  `json:"variants"`
+}
+
+type TrackVariant struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
 }
 
 type PolylineMeta struct {
@@ -33,18 +45,37 @@ type PolylineMeta struct {
 }
 
 type TrackEditor struct {
-	service     *filebased.Service
-	trackUsages *projection.TrackUsages
+	service         *filebased.Service
+	trackUsages     *projection.TrackUsages
+	trackLocations  *projection.TrackLocations
+	router          *routing.Router
+	trackVariants   *projection.TrackVariants
+	trackStatistics *projection.TrackStatistics
+	tracks          *trackCache.Cache
+	bus             *shared.EventBus
+	undo            *undo.Stack
 }
 
 func New(
-	service *filebased.Service, trackUsages *projection.TrackUsages,
+	service *filebased.Service, trackUsages *projection.TrackUsages, trackLocations *projection.TrackLocations,
+	trackVariants *projection.TrackVariants, trackStatistics *projection.TrackStatistics, router *routing.Router,
+	tracks *trackCache.Cache, bus *shared.EventBus, undo *undo.Stack,
 ) *TrackEditor {
-	return &TrackEditor{service: service, trackUsages: trackUsages}
+	return &TrackEditor{
+		service:         service,
+		trackUsages:     trackUsages,
+		trackLocations:  trackLocations,
+		trackVariants:   trackVariants,
+		trackStatistics: trackStatistics,
+		router:          router,
+		tracks:          tracks,
+		bus:             bus,
+		undo:            undo,
+	}
 }
 
 func (t *TrackEditor) GetTrack(id string) (TrackDto, error) {
-	file, err := t.service.ReadTrack(id)
+	file, err := t.tracks.Get(id)
 	if err != nil {
 		return TrackDto{}, err
 	}
@@ -57,17 +88,24 @@ func (t *TrackEditor) GetTrack(id string) (TrackDto, error) {
 	if err != nil {
 		return TrackDto{}, err
 	}
+	variants := make([]TrackVariant, 0)
+	for _, variantId := range t.trackVariants.Variants(id) {
+		variants = append(variants, TrackVariant{Id: variantId, Name: t.trackVariants.Name(variantId)})
+	}
 	return TrackDto{
-		Id:        file.Id,
-		Name:      file.Name,
-		Waypoints: waypoints,
-		Comment:   file.Comment,
+		Id:          file.Id,
+		Name:        file.Name,
+		Waypoints:   waypoints,
+		Comment:     file.Comment,
+		CommentHtml: markdown.Render(file.Comment),
 		PolylineMeta: PolylineMeta{
-			Length:          file.Waypoints.Length(),
+			Length:          file.Length,
 			DistanceMarkers: distanceMarkers,
 		},
-		Parents: file.Parents,
-		Usages:  usages,
+		Parents:     file.Parents,
+		Usages:      usages,
+		DerivesFrom: file.DerivesFrom,
+		Variants:    variants,
 	}, nil
 }
 
@@ -98,35 +136,49 @@ func (t *TrackEditor) GetPolylineMeta(dtos []CoordinateDto) PolylineMeta {
 }
 
 type SaveTrackDto struct {
-	Id        string          `json:"id"`
-	Name      string          `json:"name"`
-	Waypoints []CoordinateDto `json:"waypoints"`
-	Parents   []string        `json:"parents"`
-	Comment   string          `json:"comment"`
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Waypoints   []CoordinateDto `json:"waypoints"`
+	Parents     []string        `json:"parents"`
+	Comment     string          `json:"comment"`
+	DerivesFrom string          `json:"derivesFrom"`
 }
 
 func (t *TrackEditor) SaveTrack(track SaveTrackDto) error {
+	if track.DerivesFrom != "" {
+		if track.DerivesFrom == track.Id || t.trackVariants.IsDerivedFrom(track.DerivesFrom, track.Id) {
+			return fmt.Errorf("track %s cannot derive from its own variant %s", track.Id, track.DerivesFrom)
+		}
+		_, err := t.tracks.Get(track.DerivesFrom)
+		if err != nil {
+			return fmt.Errorf("could not read track %s to derive from: %v", track.DerivesFrom, err)
+		}
+	}
 	wp := make(shared.Waypoints, 0)
 	for _, waypoint := range track.Waypoints {
 		wp = append(wp, shared.Coordinates{Longitude: waypoint.Longitude, Latitude: waypoint.Latitude})
 	}
 	saveTrack := shared.SaveTrack{
-		Id:        track.Id,
-		Name:      track.Name,
-		Waypoints: wp,
-		Parents:   track.Parents,
-		Comment:   track.Comment,
+		Id:          track.Id,
+		Name:        track.Name,
+		Waypoints:   wp,
+		Parents:     track.Parents,
+		Comment:     track.Comment,
+		DerivesFrom: track.DerivesFrom,
 	}
-	err := t.service.SaveTrack(saveTrack)
-	shared.SendEvent(shared.TrackUpsertedEvent{SaveTrack: &saveTrack})
-	return err
-}
-
-func (t *TrackEditor) DeleteTrack(id string) error {
-	err := t.service.DeleteTrackDirectory(id)
+	change, err := t.undo.Begin("save track "+track.Name, undo.Saved(undo.Track, track.Id))
+	if err != nil {
+		return err
+	}
+	defer change.Discard()
+	err = t.service.SaveTrack(saveTrack)
+	if err != nil {
+		return err
+	}
+	change.Commit()
+	err = t.bus.Send(shared.TrackUpsertedEvent{SaveTrack: &saveTrack})
 	if err != nil {
-		return fmt.Errorf("could not delete track directory: %v", err)
+		return fmt.Errorf("could not process saved track: %v", err)
 	}
-	shared.SendEvent(shared.TrackDeletedEvent{Id: id})
-	return err
+	return nil
 }
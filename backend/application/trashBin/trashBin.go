@@ -0,0 +1,113 @@
+package trashBin
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type TrashBin struct {
+	service       *filebased.Service
+	bus           *shared.EventBus
+	retentionDays atomic.Int64
+}
+
+type TrashItemDto struct {
+	Id        string `json:"id"`
+	Kind      string `json:"kind"`
+	ObjectId  string `json:"objectId"`
+	Name      string `json:"name"`
+	DeletedAt string `json:"deletedAt"`
+	Reason    string `json:"reason"`
+}
+
+// New creates the trash bin and purges all items older than the retention; a retention of 0 days keeps
+// the items until they are purged manually
+func New(service *filebased.Service, bus *shared.EventBus, retentionDays int) *TrashBin {
+	result := &TrashBin{service: service, bus: bus}
+	result.retentionDays.Store(int64(retentionDays))
+	shared.Listen(
+		bus, shared.TrashRetentionChangedEvent{}, func(event shared.TrashRetentionChangedEvent) {
+			result.retentionDays.Store(int64(event.NewValue))
+			result.purgeExpired()
+		},
+	)
+	result.purgeExpired()
+	return result
+}
+
+func (t *TrashBin) purgeExpired() {
+	days := t.retentionDays.Load()
+	if days <= 0 {
+		return
+	}
+	count, err := t.service.PurgeTrashDeletedBefore(time.Now().AddDate(0, 0, -int(days)))
+	if err != nil {
+		log.Printf("could not purge expired trash items: %v", err)
+	} else if count > 0 {
+		log.Printf("purged %d expired trash items", count)
+	}
+}
+
+func (t *TrashBin) GetTrashItems() ([]TrashItemDto, error) {
+	items, err := t.service.ReadTrash()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TrashItemDto, 0, len(items))
+	for _, item := range items {
+		result = append(
+			result, TrashItemDto{
+				Id:        item.Id,
+				Kind:      item.Kind,
+				ObjectId:  item.ObjectId,
+				Name:      item.Name,
+				DeletedAt: item.DeletedAt.Format(time.RFC3339),
+				Reason:    item.Reason,
+			},
+		)
+	}
+	return result, nil
+}
+
+// RestoreTrashItem moves the item back and informs all listeners as if the track or journal entry was saved
+func (t *TrashBin) RestoreTrashItem(id string) error {
+	item, err := t.service.RestoreFromTrash(id)
+	if err != nil {
+		return err
+	}
+	if item.Kind == filebased.TrashedTrack {
+		track, err := t.service.ReadTrack(item.ObjectId)
+		if err != nil {
+			return fmt.Errorf("could not read restored track: %v", err)
+		}
+		err = t.bus.Send(
+			shared.TrackUpsertedEvent{
+				SaveTrack: &shared.SaveTrack{
+					Id: track.Id, Name: track.Name, Comment: track.Comment, Parents: track.Parents,
+					Waypoints: track.Waypoints, DerivesFrom: track.DerivesFrom,
+				},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("could not process restored track: %v", err)
+		}
+		return nil
+	}
+	entry, err := t.service.ReadJournalEntry(item.ObjectId)
+	if err != nil {
+		return fmt.Errorf("could not read restored journal entry: %v", err)
+	}
+	err = t.bus.Send(shared.JournalEntryUpsertedEvent{JournalEntry: &entry})
+	if err != nil {
+		return fmt.Errorf("could not process restored journal entry: %v", err)
+	}
+	return nil
+}
+
+func (t *TrashBin) PurgeTrashItem(id string) error {
+	return t.service.PurgeTrash(id)
+}
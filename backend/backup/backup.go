@@ -6,71 +6,86 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"log"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 )
 
 type Backup struct {
 	baseDirectory string
-	enabled       bool
-	push          bool
+	enabled       atomic.Bool
+	push          atomic.Bool
+	mutex         sync.Mutex
 }
 
-func Init(baseDirectory string, enabled bool, push bool) *Backup {
-	result := &Backup{baseDirectory: baseDirectory, enabled: enabled, push: push}
-	shared.Listen(shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
-		go result.doBackup("delete track")
+// Init creates the backup; changes are committed asynchronously, one commit at a time
+func Init(bus *shared.EventBus, baseDirectory string, enabled bool, push bool) *Backup {
+	result := &Backup{baseDirectory: baseDirectory}
+	result.enabled.Store(enabled)
+	result.push.Store(push)
+	shared.Subscribe(bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) error {
+		return result.doBackup("delete track")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) error {
+		return result.doBackup("upsert track")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) error {
+		return result.doBackup("change journal entry")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) error {
+		return result.doBackup("delete journal entry")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.JournalAttachmentsChangedEvent{}, func(event shared.JournalAttachmentsChangedEvent) error {
+		return result.doBackup("change journal attachments")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.SettingsChangedEvent{}, func(event shared.SettingsChangedEvent) error {
+		return result.doBackup("change settings")
+	}, shared.Async())
+	shared.Subscribe(bus, shared.MigrationEvent{}, func(event shared.MigrationEvent) error {
+		return result.doBackup(fmt.Sprintf("migrate files from version %d to version %d", event.OldVersion, event.NewVersion))
+	}, shared.Async())
+	shared.Listen(bus, shared.GitEnablementChangedEvent{}, func(event shared.GitEnablementChangedEvent) {
+		result.enabled.Store(event.NewValue)
 	})
-	shared.Listen(shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
-		go result.doBackup("upsert track")
-	})
-	shared.Listen(shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
-		go result.doBackup("change journal entry")
-	})
-	shared.Listen(shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
-		go result.doBackup("delete journal entry")
-	})
-	shared.Listen(shared.SettingsChangedEvent{}, func(event shared.SettingsChangedEvent) {
-		go result.doBackup("change settings")
-	})
-	shared.Listen(shared.MigrationEvent{}, func(event shared.MigrationEvent) {
-		go result.doBackup(fmt.Sprintf("migrate files from version %d to version %d", event.OldVersion, event.NewVersion))
-	})
-	shared.Listen(shared.GitEnablementChangedEvent{}, func(event shared.GitEnablementChangedEvent) {
-		result.enabled = event.NewValue
-	})
-	shared.Listen(shared.GitPushChangedEvent{}, func(event shared.GitPushChangedEvent) {
-		result.push = event.NewValue
+	shared.Listen(bus, shared.GitPushChangedEvent{}, func(event shared.GitPushChangedEvent) {
+		result.push.Store(event.NewValue)
 	})
 	return result
 }
 
-func (b *Backup) doBackup(message string) {
-	if !b.enabled {
-		return
+// doBackup commits all changes. The handlers of different event types run concurrently, thus a commit may
+// already contain the changes of another event; the commit for the other event is skipped then.
+func (b *Backup) doBackup(message string) error {
+	if !b.enabled.Load() {
+		return nil
 	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	out, err := exec.Command("git", "-C", b.baseDirectory, "add", "--all").CombinedOutput()
 	log.Print(string(out))
 	if err != nil {
 		runtime.EventsEmit(shared.Context, "git-error", string(out))
-		log.Printf("Failed to execute git add command: %v", err)
-		return
+		return fmt.Errorf("failed to execute git add command: %v", err)
+	}
+	out, err = exec.Command("git", "-C", b.baseDirectory, "status", "--porcelain").CombinedOutput()
+	if err == nil && len(out) == 0 {
+		return nil
 	}
 	out, err = exec.Command("git", "-C", b.baseDirectory, "commit", "-m", message).CombinedOutput()
 	log.Print(string(out))
 	if err != nil {
 		runtime.EventsEmit(shared.Context, "git-error", string(out))
-		log.Printf("Failed to execute git commit command: %v", err)
-		return
+		return fmt.Errorf("failed to execute git commit command: %v", err)
 	}
-	if !b.push {
-		return
+	if !b.push.Load() {
+		return nil
 	}
 	out, err = exec.Command("git", "-C", b.baseDirectory, "push").CombinedOutput()
 	log.Print(string(out))
 	if err != nil {
 		runtime.EventsEmit(shared.Context, "git-error", string(out))
-		log.Printf("Failed to execute git push command: %v", err)
-		return
+		return fmt.Errorf("failed to execute git push command: %v", err)
 	}
+	return nil
 }
 
 func (b *Backup) Pull() error {
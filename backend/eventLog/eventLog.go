@@ -0,0 +1,382 @@
+package eventLog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TrackImported        = "trackImported"
+	TrackUpserted        = "trackUpserted"
+	TrackDeleted         = "trackDeleted"
+	JournalEntryImported = "journalEntryImported"
+	JournalEntryUpserted = "journalEntryUpserted"
+	JournalEntryDeleted  = "journalEntryDeleted"
+)
+
+// Record is a line of the event log. After contains the track or journal entry after the change and is empty
+// for deleted objects; the state before the change is the After of the object's previous record. Tracks
+// reference their waypoints by the hash of their geometry, see trackRecord.
+type Record struct {
+	Sequence int             `json:"sequence"`
+	Time     time.Time       `json:"time"`
+	Type     string          `json:"type"`
+	Id       string          `json:"id"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+// trackRecord is the recorded state of a track. The waypoints are stored once per geometry in the geometries
+// directory, since most changes of a track do not change its waypoints. Records written before contain the
+// waypoints themselves.
+type trackRecord struct {
+	Waypoints   shared.Waypoints `json:"Waypoints,omitempty"`
+	Id          string           `json:"id"`
+	Name        string           `json:"name"`
+	Parents     []string         `json:"parents"`
+	Comment     string           `json:"string"`
+	DerivesFrom string           `json:"derivesFrom"`
+	Geometry    string           `json:"geometry,omitempty"`
+}
+
+// EventLog appends all changes of tracks and journal entries to a file. When the log is created, the
+// existing tracks and journal entries are recorded as imported, thus the log always contains the whole
+// history since then. Changes made to the files outside the app are not recorded.
+type EventLog struct {
+	mutex    sync.Mutex
+	path     string
+	sequence int
+	// the hash of the last recorded state of every track and journal entry, keyed by the record type's prefix
+	// and the id; changes that do not change the state are not recorded
+	states map[string]string
+}
+
+func Open(bus *shared.EventBus, directory string, service *filebased.Service) (*EventLog, error) {
+	result := &EventLog{
+		path:   filepath.Join(directory, "history", "events.jsonl"),
+		states: make(map[string]string),
+	}
+	err := os.MkdirAll(filepath.Dir(result.path), os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("could not create history directory: %v", err)
+	}
+	err = result.read(
+		func(record Record) {
+			result.sequence = record.Sequence
+			result.remember(record)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = result.terminateLastLine()
+	if err != nil {
+		return nil, err
+	}
+	if result.sequence == 0 {
+		err = result.importFiles(service)
+		if err != nil {
+			return nil, err
+		}
+	}
+	shared.Subscribe(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) error {
+			return result.appendTrack(TrackUpserted, trackOf(*event.SaveTrack))
+		},
+	)
+	shared.Subscribe(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) error {
+			return result.append(TrackDeleted, event.Id, nil)
+		},
+	)
+	shared.Subscribe(
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) error {
+			return result.append(JournalEntryUpserted, event.Id, event.JournalEntry)
+		},
+	)
+	shared.Subscribe(
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) error {
+			return result.append(JournalEntryDeleted, event.Id, nil)
+		},
+	)
+	return result, nil
+}
+
+func trackOf(track shared.SaveTrack) shared.Track {
+	return shared.Track{
+		Waypoints:   track.Waypoints,
+		Id:          track.Id,
+		Name:        track.Name,
+		Parents:     track.Parents,
+		Comment:     track.Comment,
+		DerivesFrom: track.DerivesFrom,
+	}
+}
+
+func (e *EventLog) importFiles(service *filebased.Service) error {
+	var appendErr error
+	err := service.ReadAllTracks(
+		func(track shared.Track) {
+			if appendErr == nil {
+				appendErr = e.appendTrack(TrackImported, track)
+			}
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("could not read tracks: %v", err)
+	}
+	if appendErr != nil {
+		return appendErr
+	}
+	entries, err := service.ReadAllJournalEntries()
+	if err != nil {
+		return fmt.Errorf("could not read journal entries: %v", err)
+	}
+	for _, entry := range entries {
+		err = e.append(JournalEntryImported, entry.Id, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateKey returns the key of the object changed by the record, e.g. "track/<id>"
+func stateKey(record Record) string {
+	if strings.HasPrefix(record.Type, "track") {
+		return "track/" + record.Id
+	}
+	return "journalEntry/" + record.Id
+}
+
+func (e *EventLog) remember(record Record) {
+	if record.After == nil {
+		delete(e.states, stateKey(record))
+	} else {
+		e.states[stateKey(record)] = hash(record.After)
+	}
+}
+
+func hash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (e *EventLog) geometryPath(geometry string) string {
+	return filepath.Join(filepath.Dir(e.path), "geometries", geometry+".json")
+}
+
+// appendTrack stores the track's waypoints in the geometries directory unless they are known already and
+// records the track with a reference to them
+func (e *EventLog) appendTrack(recordType string, track shared.Track) error {
+	waypoints, err := json.Marshal(track.Waypoints)
+	if err != nil {
+		return fmt.Errorf("could not serialize waypoints of %s: %v", track.Id, err)
+	}
+	geometry := hash(waypoints)
+	path := e.geometryPath(geometry)
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err == nil {
+			err = os.WriteFile(path, waypoints, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("could not store geometry of %s: %v", track.Id, err)
+		}
+	}
+	return e.append(
+		recordType, track.Id, trackRecord{
+			Id: track.Id, Name: track.Name, Parents: track.Parents, Comment: track.Comment,
+			DerivesFrom: track.DerivesFrom, Geometry: geometry,
+		},
+	)
+}
+
+// terminateLastLine ends a line that was only written partially so that the next record starts on a new line
+func (e *EventLog) terminateLastLine() error {
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open event log: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	_, err = file.ReadAt(last, info.Size()-1)
+	if err == nil && last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	if err != nil {
+		return fmt.Errorf("could not repair event log: %v", err)
+	}
+	return nil
+}
+
+func (e *EventLog) append(recordType string, id string, after any) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	record := Record{Sequence: e.sequence + 1, Time: time.Now(), Type: recordType, Id: id}
+	if after != nil {
+		payload, err := json.Marshal(after)
+		if err != nil {
+			return fmt.Errorf("could not serialize %s: %v", id, err)
+		}
+		record.After = payload
+	}
+	if previous, ok := e.states[stateKey(record)]; ok && after != nil && previous == hash(record.After) {
+		return nil
+	}
+	line, _ := json.Marshal(record)
+	file, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open event log: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("could not append to event log: %v", err)
+	}
+	e.sequence = record.Sequence
+	e.remember(record)
+	return nil
+}
+
+// read passes all records of the log to the consumer; lines that cannot be parsed, e.g. because the app
+// crashed while writing them, are skipped
+func (e *EventLog) read(consumer func(record Record)) error {
+	file, err := os.Open(e.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open event log: %v", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			log.Printf("skipping unreadable line of event log: %v", err)
+			continue
+		}
+		consumer(record)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("could not read event log: %v", err)
+	}
+	return nil
+}
+
+type HistoryEntry struct {
+	Sequence int            `json:"sequence"`
+	Time     string         `json:"time"`
+	Type     string         `json:"type"`
+	Before   map[string]any `json:"before"`
+	After    map[string]any `json:"after"`
+}
+
+// History returns all recorded changes of the track or journal entry with the given id, the oldest first
+func (e *EventLog) History(id string) ([]HistoryEntry, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	result := make([]HistoryEntry, 0)
+	var before json.RawMessage
+	err := e.read(
+		func(record Record) {
+			if record.Id != id {
+				return
+			}
+			entry := HistoryEntry{Sequence: record.Sequence, Time: record.Time.Format(time.RFC3339), Type: record.Type}
+			_ = json.Unmarshal(before, &entry.Before)
+			_ = json.Unmarshal(record.After, &entry.After)
+			result = append(result, entry)
+			before = record.After
+		},
+	)
+	return result, err
+}
+
+// Replay reads the whole log and returns the tracks and journal entries as they are after the last record
+func (e *EventLog) Replay() ([]shared.Track, []shared.JournalEntry, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	tracks := make(map[string]shared.Track)
+	entries := make(map[string]shared.JournalEntry)
+	err := e.read(
+		func(record Record) {
+			var err error
+			switch record.Type {
+			case TrackImported, TrackUpserted:
+				var track shared.Track
+				track, err = e.readTrack(record.After)
+				if err == nil {
+					tracks[record.Id] = track
+				}
+			case TrackDeleted:
+				delete(tracks, record.Id)
+			case JournalEntryImported, JournalEntryUpserted:
+				entry := shared.JournalEntry{}
+				err = json.Unmarshal(record.After, &entry)
+				if err == nil {
+					entries[record.Id] = entry
+				}
+			case JournalEntryDeleted:
+				delete(entries, record.Id)
+			}
+			if err != nil {
+				log.Printf("could not replay record %d: %v", record.Sequence, err)
+			}
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	trackList := make([]shared.Track, 0, len(tracks))
+	for _, track := range tracks {
+		trackList = append(trackList, track)
+	}
+	entryList := make([]shared.JournalEntry, 0, len(entries))
+	for _, entry := range entries {
+		entryList = append(entryList, entry)
+	}
+	return trackList, entryList, nil
+}
+
+func (e *EventLog) readTrack(payload json.RawMessage) (shared.Track, error) {
+	record := trackRecord{}
+	err := json.Unmarshal(payload, &record)
+	if err != nil {
+		return shared.Track{}, err
+	}
+	if record.Geometry != "" {
+		waypoints, err := os.ReadFile(e.geometryPath(record.Geometry))
+		if err == nil {
+			err = json.Unmarshal(waypoints, &record.Waypoints)
+		}
+		if err != nil {
+			return shared.Track{}, fmt.Errorf("could not read geometry %s: %v", record.Geometry, err)
+		}
+	}
+	return shared.Track{
+		Waypoints: record.Waypoints, Id: record.Id, Name: record.Name, Parents: record.Parents,
+		Comment: record.Comment, DerivesFrom: record.DerivesFrom,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package filebased
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const attachmentsDirectory = "attachments"
+const thumbnailsDirectory = ".thumbnails"
+
+func (s *Service) attachmentsPath(entryId string) (string, error) {
+	if len(entryId) < 2 || filepath.Base(entryId) != entryId || strings.HasPrefix(entryId, ".") {
+		return "", fmt.Errorf("invalid journal entry id \"%s\"", entryId)
+	}
+	return filepath.Join(s.path, journalDirectory, entryId[0:2], entryId, attachmentsDirectory), nil
+}
+
+func validateAttachmentName(name string) error {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid attachment name \"%s\"", name)
+	}
+	return nil
+}
+
+func (s *Service) SaveAttachment(entryId string, name string, content []byte) (string, error) {
+	err := validateAttachmentName(name)
+	if err != nil {
+		return "", err
+	}
+	directory, err := s.attachmentsPath(entryId)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Stat(filepath.Join(filepath.Dir(directory), "entry.json"))
+	if err != nil {
+		return "", fmt.Errorf("could not find journal entry %s: %v", entryId, err)
+	}
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return "", fmt.Errorf("could not create attachments directory: %v", err)
+	}
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for counter := 1; ; counter++ {
+		_, err = os.Stat(filepath.Join(directory, name))
+		if os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, counter, extension)
+	}
+	err = os.WriteFile(filepath.Join(directory, name), content, 0644)
+	if err != nil {
+		return "", fmt.Errorf("could not write attachment: %v", err)
+	}
+	return name, nil
+}
+
+func (s *Service) SaveThumbnail(entryId string, name string, content []byte) error {
+	path, err := s.ThumbnailPath(entryId, name)
+	if err != nil {
+		return err
+	}
+	directory := filepath.Dir(path)
+	err = os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create thumbnails directory: %v", err)
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func (s *Service) ReadAttachments(entryId string) ([]shared.Attachment, error) {
+	result := make([]shared.Attachment, 0)
+	directory, err := s.attachmentsPath(entryId)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read attachments directory: %v", err)
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, fmt.Errorf("could not read attachment %s: %v", file.Name(), err)
+		}
+		_, err = os.Stat(filepath.Join(directory, thumbnailsDirectory, file.Name()+".jpg"))
+		result = append(
+			result,
+			shared.Attachment{EntryId: entryId, Name: file.Name(), Size: info.Size(), HasThumbnail: err == nil},
+		)
+	}
+	return result, nil
+}
+
+func (s *Service) OpenAttachment(entryId string, name string) (*os.File, error) {
+	path, err := s.AttachmentPath(entryId, name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *Service) AttachmentPath(entryId string, name string) (string, error) {
+	err := validateAttachmentName(name)
+	if err != nil {
+		return "", err
+	}
+	directory, err := s.attachmentsPath(entryId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(directory, name), nil
+}
+
+func (s *Service) ThumbnailPath(entryId string, name string) (string, error) {
+	path, err := s.AttachmentPath(entryId, name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), thumbnailsDirectory, name+".jpg"), nil
+}
+
+func (s *Service) DeleteAttachment(entryId string, name string) error {
+	path, err := s.AttachmentPath(entryId, name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("could not delete attachment: %v", err)
+	}
+	thumbnail, _ := s.ThumbnailPath(entryId, name)
+	err = os.Remove(thumbnail)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete thumbnail: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package filebased
+
+import "path/filepath"
+
+// the files a track or a journal entry consists of, attachments are not included; the track.gpx of a
+// journal entry only exists if the entry embeds its track
+var TrackFiles = []string{"info.json", "track.gpx"}
+var JournalEntryFiles = []string{"entry.json", "track.gpx"}
+
+// TrackDirectory returns the directory containing all files of the track
+func (s *Service) TrackDirectory(id string) string {
+	return filepath.Join(s.path, tracksDirectory, id)
+}
+
+// JournalEntryDirectory returns the directory containing all files of the journal entry including its attachments
+func (s *Service) JournalEntryDirectory(id string) string {
+	return filepath.Join(s.path, journalDirectory, id[0:2], id)
+}
@@ -11,13 +11,23 @@ import (
 )
 
 type entryFile struct {
-	Id           string `json:"id"`
-	Track        string `json:"track"`
-	Date         string `json:"date"`
-	Time         string `json:"time"`
-	Comment      string `json:"comment"`
-	Laps         int    `json:"laps"`
-	CustomLength *int   `json:"customLength,omitempty"`
+	Id           string       `json:"id"`
+	Track        string       `json:"track"`
+	Date         string       `json:"date"`
+	Time         string       `json:"time"`
+	Comment      string       `json:"comment"`
+	Laps         int          `json:"laps"`
+	CustomLength *int         `json:"customLength,omitempty"`
+	Weather      *weatherFile `json:"weather,omitempty"`
+	// the waypoints of the embedded track are stored in the entry's directory
+	EmbeddedTrack *trackDescriptor `json:"embeddedTrack,omitempty"`
+}
+
+type weatherFile struct {
+	Temperature   float64 `json:"temperature"`
+	WindSpeed     float64 `json:"windSpeed"`
+	Precipitation float64 `json:"precipitation"`
+	Conditions    string  `json:"conditions"`
 }
 
 func (s *Service) ReadAllJournalEntries() ([]shared.JournalEntry, error) {
@@ -29,6 +39,9 @@ func (s *Service) ReadAllJournalEntries() ([]shared.JournalEntry, error) {
 				log.Printf("skipping directory \"%s\" because an error occurred: %v", path, err)
 				return filepath.SkipDir
 			}
+			if info.IsDir() && info.Name() == attachmentsDirectory {
+				return filepath.SkipDir
+			}
 			if path == walkPath || info.IsDir() {
 				return nil
 			}
@@ -66,14 +79,40 @@ func (s *Service) ReadJournalEntry(id string) (shared.JournalEntry, error) {
 	if listEntry.CustomLength != nil {
 		customLength = listEntry.CustomLength
 	}
+	var weather *shared.Weather
+	if listEntry.Weather != nil {
+		weather = &shared.Weather{
+			Temperature:   listEntry.Weather.Temperature,
+			WindSpeed:     listEntry.Weather.WindSpeed,
+			Precipitation: listEntry.Weather.Precipitation,
+			Conditions:    listEntry.Weather.Conditions,
+		}
+	}
+	var embeddedTrack *shared.Track
+	if listEntry.EmbeddedTrack != nil {
+		waypoints, err := readGpx(filepath.Join(s.JournalEntryDirectory(id), "track.gpx"))
+		if err != nil {
+			return shared.JournalEntry{}, fmt.Errorf("could not read embedded track: %v", err)
+		}
+		embeddedTrack = &shared.Track{
+			Waypoints:   waypoints,
+			Id:          listEntry.EmbeddedTrack.Id,
+			Name:        listEntry.EmbeddedTrack.Name,
+			Parents:     listEntry.EmbeddedTrack.Parents,
+			Comment:     listEntry.EmbeddedTrack.Comment,
+			DerivesFrom: listEntry.EmbeddedTrack.DerivesFrom,
+		}
+	}
 	return shared.JournalEntry{
-		TrackId:      listEntry.Track,
-		Id:           id,
-		Date:         date,
-		Comment:      listEntry.Comment,
-		CustomLength: customLength,
-		Laps:         listEntry.Laps,
-		Time:         listEntry.Time,
+		TrackId:       listEntry.Track,
+		Id:            id,
+		Date:          date,
+		Comment:       listEntry.Comment,
+		CustomLength:  customLength,
+		Laps:          listEntry.Laps,
+		Time:          listEntry.Time,
+		Weather:       weather,
+		EmbeddedTrack: embeddedTrack,
 	}, nil
 }
 
@@ -84,20 +123,56 @@ func (s *Service) SaveJournalEntry(entry shared.JournalEntry) error {
 	if err != nil {
 		return fmt.Errorf("could not create directory: %v", err)
 	}
+	var weather *weatherFile
+	if entry.Weather != nil {
+		weather = &weatherFile{
+			Temperature:   entry.Weather.Temperature,
+			WindSpeed:     entry.Weather.WindSpeed,
+			Precipitation: entry.Weather.Precipitation,
+			Conditions:    entry.Weather.Conditions,
+		}
+	}
+	var embeddedTrack *trackDescriptor
+	if entry.EmbeddedTrack != nil {
+		embeddedTrack = &trackDescriptor{
+			Id:          entry.EmbeddedTrack.Id,
+			Name:        entry.EmbeddedTrack.Name,
+			Parents:     entry.EmbeddedTrack.Parents,
+			Comment:     entry.EmbeddedTrack.Comment,
+			DerivesFrom: entry.EmbeddedTrack.DerivesFrom,
+		}
+		err = writeGpxFile(entry.EmbeddedTrack.Waypoints, path)
+	} else {
+		err = os.Remove(filepath.Join(path, "track.gpx"))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not save embedded track: %v", err)
+	}
 	payload, _ := json.Marshal(
 		entryFile{
-			Id:           entry.Id,
-			Track:        entry.TrackId,
-			Laps:         entry.Laps,
-			Date:         entry.Date.Format(time.DateOnly),
-			Time:         entry.Time,
-			Comment:      entry.Comment,
-			CustomLength: entry.CustomLength,
+			Id:            entry.Id,
+			Track:         entry.TrackId,
+			Laps:          entry.Laps,
+			Date:          entry.Date.Format(time.DateOnly),
+			Time:          entry.Time,
+			Comment:       entry.Comment,
+			CustomLength:  entry.CustomLength,
+			Weather:       weather,
+			EmbeddedTrack: embeddedTrack,
 		},
 	)
 	return os.WriteFile(filepath.Join(path, "entry.json"), payload, 0644)
 }
 
-func (s *Service) DeleteJournalEntry(id string) error {
-	return os.RemoveAll(filepath.Join(s.path, journalDirectory, id[0:2], id))
+// DeleteJournalEntry moves the journal entry including its attachments to the trash
+func (s *Service) DeleteJournalEntry(id string, reason string) error {
+	item := TrashItem{Kind: TrashedJournalEntry, ObjectId: id, Name: id, Reason: reason}
+	entry, err := s.ReadJournalEntry(id)
+	if err == nil {
+		item.Name = entry.Date.Format(time.DateOnly)
+	}
+	return s.moveToTrash(item, s.JournalEntryDirectory(id))
 }
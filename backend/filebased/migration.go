@@ -18,7 +18,7 @@ type fileVersion struct {
 
 var migrations = map[int]migrator{1: insertIdsAndRestructureFiles}
 
-func (s *Service) Migrate() error {
+func (s *Service) Migrate(bus *shared.EventBus) error {
 	version, err := s.loadCurrentFileVersion()
 	if err != nil {
 		return err
@@ -31,10 +31,13 @@ func (s *Service) Migrate() error {
 			return fmt.Errorf("error migrating from version %d to version %d: %v", v, v+1, err)
 		}
 		log.Printf("finished migration from version %d to version %d", v, v+1)
-		shared.SendEvent(shared.MigrationEvent{
+		err = bus.Send(shared.MigrationEvent{
 			OldVersion: v,
 			NewVersion: v + 1,
 		})
+		if err != nil {
+			log.Printf("could not process migration to version %d: %v", v+1, err)
+		}
 		version.Version = v + 1
 		payload, _ := json.Marshal(version)
 		err = os.WriteFile(filepath.Join(s.path, "fileVersion.json"), payload, 0644)
@@ -0,0 +1,94 @@
+package filebased
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type SourceFile struct {
+	ModTime int64 `json:"modTime"`
+	Size    int64 `json:"size"`
+}
+
+// SourceFiles returns the state of all files tracks and journal entries are read from, keyed by their
+// path relative to the service's directory. Attachments are not considered.
+func (s *Service) SourceFiles() (map[string]SourceFile, error) {
+	result := make(map[string]SourceFile)
+	for _, directory := range []string{tracksDirectory, journalDirectory} {
+		err := filepath.WalkDir(
+			filepath.Join(s.path, directory), func(path string, entry fs.DirEntry, err error) error {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if entry.IsDir() && entry.Name() == attachmentsDirectory {
+					return filepath.SkipDir
+				}
+				if entry.IsDir() || (entry.Name() != "info.json" && entry.Name() != "track.gpx" && entry.Name() != "entry.json") {
+					return nil
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				relative, _ := filepath.Rel(s.path, path)
+				result[relative] = SourceFile{ModTime: info.ModTime().UnixNano(), Size: info.Size()}
+				return nil
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not list files of %s: %v", directory, err)
+		}
+	}
+	return result, nil
+}
+
+// HashSourceFile returns the SHA-256 hash of the file given relative to the service's directory
+func (s *Service) HashSourceFile(path string) (string, error) {
+	file, err := os.Open(filepath.Join(s.path, path))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// TrackSourceFiles returns the paths of the track's source files relative to the service's directory,
+// regardless of whether they exist
+func TrackSourceFiles(id string) []string {
+	result := make([]string, 0, len(TrackFiles))
+	for _, name := range TrackFiles {
+		result = append(result, filepath.Join(tracksDirectory, id, name))
+	}
+	return result
+}
+
+// JournalEntrySourceFiles is like TrackSourceFiles for journal entries
+func JournalEntrySourceFiles(id string) []string {
+	result := make([]string, 0, len(JournalEntryFiles))
+	for _, name := range JournalEntryFiles {
+		result = append(result, filepath.Join(journalDirectory, id[0:2], id, name))
+	}
+	return result
+}
+
+// StatSourceFile returns the state of the file given relative to the service's directory
+func (s *Service) StatSourceFile(path string) (SourceFile, error) {
+	info, err := os.Stat(filepath.Join(s.path, path))
+	if err != nil {
+		return SourceFile{}, err
+	}
+	return SourceFile{ModTime: info.ModTime().UnixNano(), Size: info.Size()}, nil
+}
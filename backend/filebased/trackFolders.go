@@ -0,0 +1,61 @@
+package filebased
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// UpdateTrackParents sets the parents of all given tracks (track id → new parents). Either all info.json
+// files are rewritten or none: the new files are written next to the old ones first and only renamed
+// afterwards; if a rename fails, the already renamed files are restored.
+func (s *Service) UpdateTrackParents(parents map[string][]string) error {
+	originals := make(map[string][]byte)
+	written := make([]string, 0, len(parents))
+	removeTemporaryFiles := func() {
+		for _, id := range written {
+			_ = os.Remove(s.trackInfoPath(id) + ".tmp")
+		}
+	}
+	for id, newParents := range parents {
+		path := s.trackInfoPath(id)
+		original, err := os.ReadFile(path)
+		if err != nil {
+			removeTemporaryFiles()
+			return fmt.Errorf("could not read %s: %v", path, err)
+		}
+		var descriptor trackDescriptor
+		err = json.Unmarshal(original, &descriptor)
+		if err != nil {
+			removeTemporaryFiles()
+			return fmt.Errorf("could not parse %s: %v", path, err)
+		}
+		descriptor.Parents = newParents
+		payload, _ := json.Marshal(descriptor)
+		err = os.WriteFile(path+".tmp", payload, 0666)
+		if err != nil {
+			removeTemporaryFiles()
+			return fmt.Errorf("could not write %s: %v", path+".tmp", err)
+		}
+		originals[id] = original
+		written = append(written, id)
+	}
+	for index, id := range written {
+		path := s.trackInfoPath(id)
+		err := os.Rename(path+".tmp", path)
+		if err == nil {
+			continue
+		}
+		for _, restoreId := range written[:index] {
+			_ = os.WriteFile(s.trackInfoPath(restoreId), originals[restoreId], 0666)
+		}
+		removeTemporaryFiles()
+		return fmt.Errorf("could not replace %s: %v", path, err)
+	}
+	return nil
+}
+
+func (s *Service) trackInfoPath(id string) string {
+	return filepath.Join(s.path, tracksDirectory, id, "info.json")
+}
@@ -15,10 +15,11 @@ import (
 var tracksDirectory = "tracks"
 
 type trackDescriptor struct {
-	Id      string   `json:"id"`
-	Name    string   `json:"name"`
-	Parents []string `json:"parents"`
-	Comment string   `json:"comment"`
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Parents     []string `json:"parents"`
+	Comment     string   `json:"comment"`
+	DerivesFrom string   `json:"derivesFrom,omitempty"`
 }
 
 func (s *Service) ReadAllTracks(consumer func(track shared.Track)) error {
@@ -44,6 +45,24 @@ func (s *Service) ReadAllTracks(consumer func(track shared.Track)) error {
 	)
 }
 
+// TrackIds returns the ids of all tracks without reading them
+func (s *Service) TrackIds() ([]string, error) {
+	directories, err := os.ReadDir(filepath.Join(s.path, tracksDirectory))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(directories))
+	for _, directory := range directories {
+		if directory.IsDir() {
+			result = append(result, directory.Name())
+		}
+	}
+	return result, nil
+}
+
 func (s *Service) ReadTrack(id string) (shared.Track, error) {
 	descriptorPath := filepath.Join(s.path, tracksDirectory, id, "info.json")
 	var baseDescriptor trackDescriptor
@@ -63,11 +82,12 @@ func (s *Service) ReadTrack(id string) (shared.Track, error) {
 	}
 
 	return shared.Track{
-		Waypoints: waypoints,
-		Id:        id,
-		Name:      baseDescriptor.Name,
-		Parents:   baseDescriptor.Parents,
-		Comment:   baseDescriptor.Comment,
+		Waypoints:   waypoints,
+		Id:          id,
+		Name:        baseDescriptor.Name,
+		Parents:     baseDescriptor.Parents,
+		Comment:     baseDescriptor.Comment,
+		DerivesFrom: baseDescriptor.DerivesFrom,
 	}, nil
 }
 
@@ -76,12 +96,20 @@ func readGpx(path string) (shared.Waypoints, error) {
 	if err != nil {
 		return nil, fmt.Errorf("ocould not read gpx track %s: %v", path, err)
 	}
-	tracks, err := gpx.Read(bytes.NewReader(gpxFileContent))
+	result, err := ParseGpx(gpxFileContent)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse gpx %s: %v", path, err)
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return result, nil
+}
+
+func ParseGpx(content []byte) (shared.Waypoints, error) {
+	tracks, err := gpx.Read(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse gpx: %v", err)
 	}
 	if len(tracks.Trk) != 1 {
-		return nil, fmt.Errorf("%s must contain one track only, but contains %d tracks", path, len(tracks.Trk))
+		return nil, fmt.Errorf("gpx must contain one track only, but contains %d tracks", len(tracks.Trk))
 	}
 	coordinates := make([]shared.Coordinates, 0, 0)
 	for _, segment := range tracks.Trk[0].TrkSeg {
@@ -99,7 +127,12 @@ func (s *Service) SaveTrack(track shared.SaveTrack) error {
 		return fmt.Errorf("could not create track directory %s: %v", trackDirectory, err)
 	}
 	infoFile := filepath.Join(trackDirectory, "info.json")
-	infoPayload, _ := json.Marshal(trackDescriptor{Name: track.Name, Id: track.Id, Parents: track.Parents, Comment: track.Comment})
+	infoPayload, _ := json.Marshal(
+		trackDescriptor{
+			Name: track.Name, Id: track.Id, Parents: track.Parents, Comment: track.Comment,
+			DerivesFrom: track.DerivesFrom,
+		},
+	)
 	err = os.WriteFile(infoFile, infoPayload, 0666)
 	if err != nil {
 		return fmt.Errorf("could not save base information: %v", err)
@@ -125,7 +158,12 @@ func writeGpxFile(waypoints shared.Waypoints, trackDirectory string) error {
 	return os.WriteFile(filepath.Join(trackDirectory, "track.gpx"), writer.Bytes(), 0644)
 }
 
-func (s *Service) DeleteTrackDirectory(id string) error {
-	err := os.RemoveAll(filepath.Join(s.path, "tracks", id))
-	return err
+// DeleteTrackDirectory moves the track to the trash
+func (s *Service) DeleteTrackDirectory(id string, reason string) error {
+	item := TrashItem{Kind: TrashedTrack, ObjectId: id, Name: id, Reason: reason}
+	track, err := s.ReadTrack(id)
+	if err == nil {
+		item.Name = track.Name
+	}
+	return s.moveToTrash(item, s.TrackDirectory(id))
 }
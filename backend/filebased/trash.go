@@ -0,0 +1,171 @@
+package filebased
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const trashDirectory = ".trash"
+
+const (
+	TrashedTrack        = "track"
+	TrashedJournalEntry = "journalEntry"
+)
+
+// TrashItem describes a deleted track or journal entry. The item's directory contains the metadata file and
+// the content of the deleted object's directory.
+type TrashItem struct {
+	Id        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	ObjectId  string    `json:"objectId"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deletedAt"`
+	Reason    string    `json:"reason"`
+}
+
+func (s *Service) trashItemDirectory(id string) string {
+	return filepath.Join(s.path, trashDirectory, id)
+}
+
+func (s *Service) moveToTrash(item TrashItem, directory string) error {
+	if _, err := os.Stat(directory); err != nil {
+		return fmt.Errorf("could not move %s to trash: %v", item.ObjectId, err)
+	}
+	item.Id = shared.UniqueId()
+	item.DeletedAt = time.Now()
+	itemDirectory := s.trashItemDirectory(item.Id)
+	err := os.MkdirAll(itemDirectory, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("could not create trash directory: %v", err)
+	}
+	payload, _ := json.MarshalIndent(item, "", "  ")
+	err = os.WriteFile(filepath.Join(itemDirectory, "trash.json"), payload, 0644)
+	if err == nil {
+		err = os.Rename(directory, filepath.Join(itemDirectory, "content"))
+	}
+	if err != nil {
+		_ = os.RemoveAll(itemDirectory)
+		return fmt.Errorf("could not move %s to trash: %v", item.ObjectId, err)
+	}
+	return nil
+}
+
+// ReadTrash returns all items in the trash, the most recently deleted first
+func (s *Service) ReadTrash() ([]TrashItem, error) {
+	result := make([]TrashItem, 0)
+	directories, err := os.ReadDir(filepath.Join(s.path, trashDirectory))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read trash: %v", err)
+	}
+	for _, directory := range directories {
+		item, err := s.readTrashItem(directory.Name())
+		if err != nil {
+			log.Printf("skipping trash item: %v", err)
+			continue
+		}
+		result = append(result, item)
+	}
+	slices.SortFunc(
+		result, func(a TrashItem, b TrashItem) int {
+			return b.DeletedAt.Compare(a.DeletedAt)
+		},
+	)
+	return result, nil
+}
+
+// LatestTrashItem returns the item of the object that was moved to the trash most recently
+func (s *Service) LatestTrashItem(kind string, objectId string) (TrashItem, error) {
+	items, err := s.ReadTrash()
+	if err != nil {
+		return TrashItem{}, err
+	}
+	for _, item := range items {
+		if item.Kind == kind && item.ObjectId == objectId {
+			return item, nil
+		}
+	}
+	return TrashItem{}, fmt.Errorf("%s %s is not in the trash", kind, objectId)
+}
+
+func (s *Service) readTrashItem(id string) (TrashItem, error) {
+	if id == "" || filepath.Base(id) != id {
+		return TrashItem{}, fmt.Errorf("invalid trash item \"%s\"", id)
+	}
+	payload, err := os.ReadFile(filepath.Join(s.trashItemDirectory(id), "trash.json"))
+	if err != nil {
+		return TrashItem{}, fmt.Errorf("could not read trash item %s: %v", id, err)
+	}
+	item := TrashItem{}
+	err = json.Unmarshal(payload, &item)
+	if err != nil {
+		return TrashItem{}, fmt.Errorf("could not parse trash item %s: %v", id, err)
+	}
+	item.Id = id
+	return item, nil
+}
+
+// RestoreFromTrash moves the item back to its original location; this fails if the object exists again
+func (s *Service) RestoreFromTrash(id string) (TrashItem, error) {
+	item, err := s.readTrashItem(id)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	target := s.TrackDirectory(item.ObjectId)
+	if item.Kind == TrashedJournalEntry {
+		target = s.JournalEntryDirectory(item.ObjectId)
+	}
+	if _, err := os.Stat(target); err == nil {
+		return TrashItem{}, fmt.Errorf("%s \"%s\" exists already", item.Kind, item.Name)
+	}
+	err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err == nil {
+		err = os.Rename(filepath.Join(s.trashItemDirectory(id), "content"), target)
+	}
+	if err != nil {
+		return TrashItem{}, fmt.Errorf("could not restore %s: %v", item.ObjectId, err)
+	}
+	return item, os.RemoveAll(s.trashItemDirectory(id))
+}
+
+// PurgeTrash deletes the item irrevocably
+func (s *Service) PurgeTrash(id string) error {
+	_, err := s.readTrashItem(id)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(s.trashItemDirectory(id))
+	if err != nil {
+		return fmt.Errorf("could not purge trash item %s: %v", id, err)
+	}
+	return nil
+}
+
+// PurgeTrashDeletedBefore deletes all items that were moved to the trash before the given time and
+// returns their number
+func (s *Service) PurgeTrashDeletedBefore(limit time.Time) (int, error) {
+	items, err := s.ReadTrash()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, item := range items {
+		if !item.DeletedAt.Before(limit) {
+			continue
+		}
+		err = s.PurgeTrash(item.Id)
+		if err != nil {
+			return count, err
+		}
+		count = count + 1
+	}
+	return count, nil
+}
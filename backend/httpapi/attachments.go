@@ -0,0 +1,33 @@
+package httpapi
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"net/http"
+	"strings"
+)
+
+type AttachmentServer struct {
+	fileService *filebased.Service
+}
+
+func NewAttachmentServer(fileService *filebased.Service) *AttachmentServer {
+	return &AttachmentServer{fileService: fileService}
+}
+
+func (a *AttachmentServer) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.Error(resp, "expected /attachments/{entryId}/{name}", http.StatusBadRequest)
+		return
+	}
+	path, err := a.fileService.AttachmentPath(parts[1], parts[2])
+	if request.URL.Query().Has("thumbnail") {
+		path, err = a.fileService.ThumbnailPath(parts[1], parts[2])
+	}
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(resp, request, path)
+}
@@ -0,0 +1,258 @@
+package httpapi
+
+import (
+	"bytes"
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// radius (in pixels) of the line drawn for each track
+const heatmapLineRadius = 2
+
+var heatmapDirMutex sync.Mutex
+
+// HeatmapServer renders tiles showing all tracks weighted by the number of journal entries using them.
+// Rendered tiles are cached on disk per maximum usage until a track drawn on them or a journal entry
+// of such a track changes.
+type HeatmapServer struct {
+	baseDir        string
+	trackLocations *projection.TrackLocations
+	trackUsages    *projection.TrackUsages
+	// incremented by every invalidation, guarded by heatmapDirMutex; a rendered tile is only cached if no
+	// invalidation happened while rendering it
+	generation uint64
+	// the bounding boxes of the tracks drawn on cached tiles, guarded by heatmapDirMutex
+	drawn map[string]shared.BoundingBox
+}
+
+func NewHeatmapServer(
+	bus *shared.EventBus, baseDir string, trackLocations *projection.TrackLocations,
+	trackUsages *projection.TrackUsages,
+) *HeatmapServer {
+	result := &HeatmapServer{
+		baseDir: baseDir, trackLocations: trackLocations, trackUsages: trackUsages,
+		drawn: make(map[string]shared.BoundingBox),
+	}
+	shared.Listen(bus, shared.TrackUpsertedEvent{}, func(k shared.TrackUpsertedEvent) {
+		result.invalidate(k.Id, k.Waypoints)
+	})
+	shared.Listen(bus, shared.TrackDeletedEvent{}, func(k shared.TrackDeletedEvent) {
+		result.invalidate(k.Id, nil)
+	})
+	shared.Listen(bus, shared.JournalEntryUpsertedEvent{}, func(k shared.JournalEntryUpsertedEvent) {
+		result.invalidate(k.TrackId, trackLocations.Waypoints(k.TrackId))
+		if k.OldTrackId != k.TrackId {
+			result.invalidate(k.OldTrackId, trackLocations.Waypoints(k.OldTrackId))
+		}
+	})
+	shared.Listen(bus, shared.JournalEntryDeletedEvent{}, func(k shared.JournalEntryDeletedEvent) {
+		result.invalidate(k.TrackId, trackLocations.Waypoints(k.TrackId))
+	})
+	return result
+}
+
+// Close removes the cached tiles
+func (h *HeatmapServer) Close() error {
+	heatmapDirMutex.Lock()
+	defer heatmapDirMutex.Unlock()
+	h.generation = h.generation + 1
+	return os.RemoveAll(h.baseDir)
+}
+
+// invalidate removes the cached tiles on which the track was drawn or is drawn with the given waypoints
+func (h *HeatmapServer) invalidate(id string, waypoints shared.Waypoints) {
+	if id == "" {
+		return
+	}
+	heatmapDirMutex.Lock()
+	defer heatmapDirMutex.Unlock()
+	h.generation = h.generation + 1
+	boxes := make([]shared.BoundingBox, 0, 2)
+	if box, ok := h.drawn[id]; ok {
+		boxes = append(boxes, box)
+		delete(h.drawn, id)
+	}
+	if len(waypoints) > 0 {
+		boxes = append(boxes, waypoints.BoundingBox())
+	}
+	if len(boxes) == 0 {
+		return
+	}
+	_ = filepath.WalkDir(
+		h.baseDir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			relative, err := filepath.Rel(h.baseDir, path)
+			if err != nil {
+				return nil
+			}
+			// the path consists of the maximum usage, z, x, and y
+			parts := strings.Split(strings.TrimSuffix(relative, ".png"), string(filepath.Separator))
+			if len(parts) != 4 {
+				return nil
+			}
+			z, errZ := strconv.Atoi(parts[1])
+			x, errX := strconv.Atoi(parts[2])
+			y, errY := strconv.Atoi(parts[3])
+			if errZ != nil || errX != nil || errY != nil {
+				return nil
+			}
+			tile := tileBox(z, x, y)
+			if slices.ContainsFunc(boxes, tile.Intersects) {
+				_ = os.Remove(path)
+			}
+			return nil
+		},
+	)
+}
+
+func (h *HeatmapServer) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if len(parts) != 4 {
+		http.Error(resp, "expected /heatmap/{z}/{x}/{y}", http.StatusBadRequest)
+		return
+	}
+	z, errZ := strconv.Atoi(parts[1])
+	x, errX := strconv.Atoi(parts[2])
+	y, errY := strconv.Atoi(strings.TrimSuffix(parts[3], ".png"))
+	if errZ != nil || errX != nil || errY != nil || z < 0 || z > 22 || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		http.Error(resp, "invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+	usages := h.trackUsages.Get()
+	maxUsage := 0
+	for _, entries := range usages {
+		maxUsage = max(maxUsage, len(entries))
+	}
+	cacheFile := filepath.Join(
+		h.baseDir, strconv.Itoa(maxUsage), strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png",
+	)
+	resp.Header().Set("Content-Type", "image/png")
+	resp.Header().Set("Cache-Control", "no-cache")
+	heatmapDirMutex.Lock()
+	tile, err := os.ReadFile(cacheFile)
+	generation := h.generation
+	heatmapDirMutex.Unlock()
+	if err != nil {
+		var drawn map[string]shared.BoundingBox
+		tile, drawn, err = h.renderTile(z, x, y, usages, maxUsage)
+		if err != nil {
+			http.Error(resp, "could not render heatmap tile", http.StatusInternalServerError)
+			log.Printf("could not render heatmap tile %d/%d/%d: %v", z, x, y, err)
+			return
+		}
+		go func() {
+			heatmapDirMutex.Lock()
+			defer heatmapDirMutex.Unlock()
+			if h.generation != generation {
+				return
+			}
+			maps.Copy(h.drawn, drawn)
+			_ = os.MkdirAll(filepath.Dir(cacheFile), os.ModePerm)
+			_ = os.WriteFile(cacheFile, tile, 0644)
+		}()
+	}
+	resp.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+	_, _ = resp.Write(tile)
+}
+
+// renderTile returns the tile and the bounding boxes of the tracks drawn on it
+func (h *HeatmapServer) renderTile(
+	z int, x int, y int, usages projection.TrackUsagesMap, maxUsage int,
+) ([]byte, map[string]shared.BoundingBox, error) {
+	heat := make([]float64, tileSize*tileSize)
+	drawn := make(map[string]shared.BoundingBox)
+	originX, originY := float64(x*tileSize), float64(y*tileSize)
+	for _, location := range h.trackLocations.FindInArea(tileBox(z, x, y)) {
+		weight := float64(len(usages[location.Id]))
+		if weight == 0 {
+			continue
+		}
+		mask := make([]bool, tileSize*tileSize)
+		waypoints := h.trackLocations.Waypoints(location.Id)
+		drawn[location.Id] = waypoints.BoundingBox()
+		for index := 0; index < len(waypoints)-1; index++ {
+			x0, y0 := worldPixel(waypoints[index], z)
+			x1, y1 := worldPixel(waypoints[index+1], z)
+			drawLine(mask, x0-originX, y0-originY, x1-originX, y1-originY)
+		}
+		for i := range mask {
+			if mask[i] {
+				heat[i] = heat[i] + weight
+			}
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize, tileSize))
+	saturation := math.Log(1 + 2*float64(max(maxUsage, 1)))
+	for i, value := range heat {
+		if value == 0 {
+			continue
+		}
+		img.SetNRGBA(i%tileSize, i/tileSize, heatColor(math.Min(1, math.Log(1+value)/saturation)))
+	}
+	result := bytes.Buffer{}
+	err := png.Encode(&result, img)
+	return result.Bytes(), drawn, err
+}
+
+// tileBox returns the area of the tile enlarged by the line radius such that lines crossing the tile border
+// are drawn completely
+func tileBox(z int, x int, y int) shared.BoundingBox {
+	northWest := tileToCoordinates(float64(x)-0.02, float64(y)-0.02, z)
+	southEast := tileToCoordinates(float64(x+1)+0.02, float64(y+1)+0.02, z)
+	return shared.BoundingBox{
+		MinLatitude:  southEast.Latitude,
+		MinLongitude: northWest.Longitude,
+		MaxLatitude:  northWest.Latitude,
+		MaxLongitude: southEast.Longitude,
+	}
+}
+
+// heatColor maps the intensity (between 0 and 1) to a color ranging from blue over yellow to red
+func heatColor(intensity float64) color.NRGBA {
+	alpha := uint8(120 + 135*intensity)
+	if intensity < 0.5 {
+		ratio := intensity * 2
+		return color.NRGBA{R: uint8(255 * ratio), G: uint8(255 * ratio), B: uint8(255 * (1 - ratio)), A: alpha}
+	}
+	ratio := (intensity - 0.5) * 2
+	return color.NRGBA{R: 255, G: uint8(255 * (1 - ratio)), B: 0, A: alpha}
+}
+
+func drawLine(mask []bool, x0 float64, y0 float64, x1 float64, y1 float64) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for step := 0; step <= steps; step++ {
+		ratio := 0.0
+		if steps > 0 {
+			ratio = float64(step) / float64(steps)
+		}
+		stamp(mask, int(math.Round(x0+ratio*(x1-x0))), int(math.Round(y0+ratio*(y1-y0))))
+	}
+}
+
+func stamp(mask []bool, centerX int, centerY int) {
+	for dy := -heatmapLineRadius; dy <= heatmapLineRadius; dy++ {
+		for dx := -heatmapLineRadius; dx <= heatmapLineRadius; dx++ {
+			x, y := centerX+dx, centerY+dy
+			if dx*dx+dy*dy > heatmapLineRadius*heatmapLineRadius || x < 0 || y < 0 || x >= tileSize || y >= tileSize {
+				continue
+			}
+			mask[y*tileSize+x] = true
+		}
+	}
+}
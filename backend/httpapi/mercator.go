@@ -0,0 +1,25 @@
+package httpapi
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+)
+
+const tileSize = 256
+
+// worldPixel returns the pixel position of the coordinates in the web mercator projection at the given zoom
+func worldPixel(c shared.Coordinates, z int) (float64, float64) {
+	scale := float64(int(1)<<z) * tileSize
+	latitude := c.Latitude * math.Pi / 180
+	x := (c.Longitude + 180) / 360 * scale
+	y := (1 - math.Log(math.Tan(latitude)+1/math.Cos(latitude))/math.Pi) / 2 * scale
+	return x, y
+}
+
+func tileToCoordinates(x float64, y float64, z int) shared.Coordinates {
+	n := math.Pi - 2*math.Pi*y/float64(int(1)<<z)
+	return shared.Coordinates{
+		Latitude:  180 / math.Pi * math.Atan(0.5*(math.Exp(n)-math.Exp(-n))),
+		Longitude: x/float64(int(1)<<z)*360 - 180,
+	}
+}
@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	staticMapPadding   = 24
+	staticMapMaxZoom   = 18
+	staticMapMaxSize   = 4096
+	staticMapLineWidth = 3
+	staticMapMarker    = 5
+	staticMapIcon      = 7
+	// the number of tiles fetched concurrently
+	staticMapFetchers = 6
+)
+
+var (
+	trackColor      = color.NRGBA{R: 37, G: 99, B: 235, A: 255}
+	startColor      = color.NRGBA{R: 22, G: 163, B: 74, A: 255}
+	finishColor     = color.NRGBA{R: 220, G: 38, B: 38, A: 255}
+	markerColor     = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	outlineColor    = color.NRGBA{R: 30, G: 30, B: 30, A: 255}
+	missingTile     = color.NRGBA{R: 230, G: 230, B: 230, A: 255}
+	attributionBack = color.NRGBA{R: 255, G: 255, B: 255, A: 200}
+)
+
+var htmlTagMatcher = regexp.MustCompile(`<[^>]*>`)
+
+// StaticMapRenderer draws tracks onto a map image composed of the tiles of the TileServer
+type StaticMapRenderer struct {
+	tileServer  *TileServer
+	tracks      *trackCache.Cache
+	attribution func() string
+}
+
+func NewStaticMapRenderer(
+	tileServer *TileServer, tracks *trackCache.Cache, attribution func() string,
+) *StaticMapRenderer {
+	return &StaticMapRenderer{tileServer: tileServer, tracks: tracks, attribution: attribution}
+}
+
+// ServeHTTP serves /static-map/{trackId}?width=…&height=…
+func (s *StaticMapRenderer) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.Error(resp, "expected /static-map/{trackId}", http.StatusBadRequest)
+		return
+	}
+	width, err := strconv.Atoi(request.URL.Query().Get("width"))
+	if err != nil {
+		width = 800
+	}
+	height, err := strconv.Atoi(request.URL.Query().Get("height"))
+	if err != nil {
+		height = 600
+	}
+	image, err := s.RenderTrack(parts[1], width, height)
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp.Header().Set("Content-Type", "image/png")
+	resp.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	resp.Header().Set("Cache-Control", "no-cache")
+	_, _ = resp.Write(image)
+}
+
+func (s *StaticMapRenderer) RenderTrack(id string, width int, height int) ([]byte, error) {
+	track, err := s.tracks.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read track: %v", err)
+	}
+	return s.Render(track.Waypoints, width, height)
+}
+
+// Render draws the waypoints with distance markers as well as start and finish icons onto the map and
+// returns the result as PNG. The map section and zoom level are chosen such that all waypoints are visible.
+func (s *StaticMapRenderer) Render(waypoints shared.Waypoints, width int, height int) ([]byte, error) {
+	if len(waypoints) == 0 {
+		return nil, fmt.Errorf("cannot render a map without waypoints")
+	}
+	if width <= 2*staticMapPadding || height <= 2*staticMapPadding || width > staticMapMaxSize || height > staticMapMaxSize {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	z := zoomToFit(waypoints, width-2*staticMapPadding, height-2*staticMapPadding)
+	box := waypoints.BoundingBox()
+	minX, maxY := worldPixel(shared.Coordinates{Latitude: box.MinLatitude, Longitude: box.MinLongitude}, z)
+	maxX, minY := worldPixel(shared.Coordinates{Latitude: box.MaxLatitude, Longitude: box.MaxLongitude}, z)
+	left := math.Round((minX+maxX)/2 - float64(width)/2)
+	top := math.Round((minY+maxY)/2 - float64(height)/2)
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	s.drawTiles(canvas, z, left, top)
+	toCanvas := func(c shared.Coordinates) (float64, float64) {
+		x, y := worldPixel(c, z)
+		return x - left, y - top
+	}
+	for index := 0; index < len(waypoints)-1; index++ {
+		x0, y0 := toCanvas(waypoints[index])
+		x1, y1 := toCanvas(waypoints[index+1])
+		drawThickLine(canvas, x0, y0, x1, y1, staticMapLineWidth, trackColor)
+	}
+	for _, marker := range waypoints.DistanceMarkers() {
+		x, y := toCanvas(marker.Coordinates)
+		drawDisc(canvas, x, y, staticMapMarker+1, outlineColor)
+		drawDisc(canvas, x, y, staticMapMarker, markerColor)
+		drawText(canvas, int(x)+staticMapMarker+2, int(y)+4, strconv.Itoa(marker.Distance/1000), outlineColor)
+	}
+	x, y := toCanvas(waypoints[len(waypoints)-1])
+	drawDisc(canvas, x, y, staticMapIcon+1, outlineColor)
+	drawDisc(canvas, x, y, staticMapIcon, finishColor)
+	x, y = toCanvas(waypoints[0])
+	drawDisc(canvas, x, y, staticMapIcon+1, outlineColor)
+	drawDisc(canvas, x, y, staticMapIcon, startColor)
+	s.drawAttribution(canvas)
+
+	result := bytes.Buffer{}
+	err := png.Encode(&result, canvas)
+	return result.Bytes(), err
+}
+
+func zoomToFit(waypoints shared.Waypoints, width int, height int) int {
+	box := waypoints.BoundingBox()
+	for z := staticMapMaxZoom; z > 0; z-- {
+		minX, maxY := worldPixel(shared.Coordinates{Latitude: box.MinLatitude, Longitude: box.MinLongitude}, z)
+		maxX, minY := worldPixel(shared.Coordinates{Latitude: box.MaxLatitude, Longitude: box.MaxLongitude}, z)
+		if maxX-minX <= float64(width) && maxY-minY <= float64(height) {
+			return z
+		}
+	}
+	return 0
+}
+
+func (s *StaticMapRenderer) drawTiles(canvas *image.NRGBA, z int, left float64, top float64) {
+	bounds := canvas.Bounds()
+	tiles := 1 << z
+	group := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	fetchers := make(chan struct{}, staticMapFetchers)
+	for tileY := int(math.Floor(top / tileSize)); float64(tileY*tileSize) < top+float64(bounds.Dy()); tileY++ {
+		for tileX := int(math.Floor(left / tileSize)); float64(tileX*tileSize) < left+float64(bounds.Dx()); tileX++ {
+			target := image.Rect(0, 0, tileSize, tileSize).Add(
+				image.Pt(tileX*tileSize-int(left), tileY*tileSize-int(top)),
+			)
+			if tileY < 0 || tileY >= tiles {
+				draw.Draw(canvas, target, image.NewUniform(missingTile), image.Point{}, draw.Src)
+				continue
+			}
+			group.Add(1)
+			fetchers <- struct{}{}
+			go func(tileX int, tileY int) {
+				defer group.Done()
+				tile, err := s.loadTile(z, ((tileX%tiles)+tiles)%tiles, tileY)
+				<-fetchers
+				mutex.Lock()
+				defer mutex.Unlock()
+				if err != nil {
+					log.Printf("could not load tile %d/%d/%d for static map: %v", z, tileX, tileY, err)
+					draw.Draw(canvas, target, image.NewUniform(missingTile), image.Point{}, draw.Src)
+					return
+				}
+				draw.Draw(canvas, target, tile, tile.Bounds().Min, draw.Src)
+			}(tileX, tileY)
+		}
+	}
+	group.Wait()
+}
+
+func (s *StaticMapRenderer) loadTile(z int, x int, y int) (image.Image, error) {
+	content, err := s.tileServer.Tile(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	tile, _, err := image.Decode(bytes.NewReader(content))
+	return tile, err
+}
+
+func (s *StaticMapRenderer) drawAttribution(canvas *image.NRGBA) {
+	text := strings.TrimSpace(html.UnescapeString(htmlTagMatcher.ReplaceAllString(s.attribution(), "")))
+	if text == "" {
+		return
+	}
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 8
+	bounds := canvas.Bounds()
+	background := image.Rect(bounds.Max.X-width, bounds.Max.Y-18, bounds.Max.X, bounds.Max.Y)
+	draw.Draw(canvas, background, image.NewUniform(attributionBack), image.Point{}, draw.Over)
+	drawText(canvas, background.Min.X+4, bounds.Max.Y-5, text, outlineColor)
+}
+
+func drawText(canvas *image.NRGBA, x int, y int, text string, c color.Color) {
+	drawer := font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+func drawThickLine(canvas *image.NRGBA, x0 float64, y0 float64, x1 float64, y1 float64, width float64, c color.NRGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for step := 0; step <= steps; step++ {
+		ratio := 0.0
+		if steps > 0 {
+			ratio = float64(step) / float64(steps)
+		}
+		drawDisc(canvas, x0+ratio*(x1-x0), y0+ratio*(y1-y0), width/2, c)
+	}
+}
+
+func drawDisc(canvas *image.NRGBA, centerX float64, centerY float64, radius float64, c color.NRGBA) {
+	bounds := canvas.Bounds()
+	for y := int(math.Floor(centerY - radius)); y <= int(math.Ceil(centerY+radius)); y++ {
+		for x := int(math.Floor(centerX - radius)); x <= int(math.Ceil(centerX+radius)); x++ {
+			if !image.Pt(x, y).In(bounds) {
+				continue
+			}
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			if dx*dx+dy*dy <= radius*radius {
+				canvas.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
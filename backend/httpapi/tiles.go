@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -21,13 +22,13 @@ type TileServer struct {
 	cacheEnabled bool
 }
 
-func NewTileServer(baseDir string, url string, cacheEnabled bool) *TileServer {
+func NewTileServer(bus *shared.EventBus, baseDir string, url string, cacheEnabled bool) *TileServer {
 	result := TileServer{url: url, baseDir: filepath.Join(baseDir, "tiles"), cacheEnabled: cacheEnabled}
-	shared.Listen(shared.TileServerChangedEvent{}, func(k shared.TileServerChangedEvent) {
+	shared.Listen(bus, shared.TileServerChangedEvent{}, func(k shared.TileServerChangedEvent) {
 		result.url = k.NewValue
 		_ = os.RemoveAll(result.baseDir)
 	})
-	shared.Listen(shared.TileServerCacheEnabledEvent{}, func(k shared.TileServerCacheEnabledEvent) {
+	shared.Listen(bus, shared.TileServerCacheEnabledEvent{}, func(k shared.TileServerCacheEnabledEvent) {
 		result.cacheEnabled = k.NewValue
 		if !result.cacheEnabled {
 			_ = os.RemoveAll(result.baseDir)
@@ -42,21 +43,55 @@ func (t *TileServer) ServeHTTP(resp http.ResponseWriter, originalRequest *http.R
 	x := parts[3]
 	y := parts[4]
 	resp.Header().Set("Content-Type", "image/png")
-	if t.readCacheFile(z, x, y, resp) {
+	if tile, ok := t.readCacheFile(z, x, y); ok {
+		resp.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+		resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		resp.Header().Set("Pragma", "no-cache")
+		resp.Header().Set("Expires", "0")
+		_, _ = resp.Write(tile)
 		return
 	}
+	body, err := t.fetchTile(z, x, y)
+	if err != nil {
+		http.Error(resp, "could not get tile from tile server", http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header().Set("Cache-Control", "public, max-age=86400")
+	_, _ = resp.Write(body)
+}
+
+// Tile returns the tile from the cache or fetches it from the tile server
+func (t *TileServer) Tile(z int, x int, y int) ([]byte, error) {
+	zoom, column, row := strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)
+	if tile, ok := t.readCacheFile(zoom, column, row); ok {
+		return tile, nil
+	}
+	return t.fetchTile(zoom, column, row)
+}
+
+func (t *TileServer) fetchTile(z string, x string, y string) ([]byte, error) {
 	url := strings.Replace(t.url, "{z}", z, 1)
 	url = strings.Replace(url, "{x}", x, 1)
 	url = strings.Replace(url, "{y}", y, 1)
 	client := http.Client{Timeout: time.Second * 10}
-	req, _ := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create tile request: %v", err)
+	}
 	req.Header.Set("User-Agent", "github.com/fafeitsch/private-running-journal")
 	response, err := client.Do(req)
 	if err != nil {
-		http.Error(resp, "could not get tile from tile server", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile server answered with status %d", response.StatusCode)
 	}
 	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
 	if t.cacheEnabled {
 		go func() {
 			tilesDirMutex.Lock()
@@ -65,27 +100,20 @@ func (t *TileServer) ServeHTTP(resp http.ResponseWriter, originalRequest *http.R
 			_ = os.WriteFile(filepath.Join(t.baseDir, z, x, y)+".png", body, 0644)
 		}()
 	}
-	resp.Header().Set("Content-Length", strconv.Itoa(len(body)))
-	resp.Header().Set("Cache-Control", "public, max-age=86400")
-	_, _ = resp.Write(body)
+	return body, nil
 }
 
-func (t *TileServer) readCacheFile(z string, x string, y string, resp http.ResponseWriter) bool {
+func (t *TileServer) readCacheFile(z string, x string, y string) ([]byte, bool) {
 	tilesDirMutex.Lock()
 	defer tilesDirMutex.Unlock()
 	cachedFile, err := os.Stat(filepath.Join(t.baseDir, z, x, y) + ".png")
 	if err != nil || time.Now().Sub(cachedFile.ModTime()) >= 24*time.Hour*180 {
-		return false
+		return nil, false
 	}
 	tile, err := os.ReadFile(filepath.Join(t.baseDir, z, x, y) + ".png")
-	resp.Header().Set("Content-Length", strconv.Itoa(len(tile)))
-	resp.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	resp.Header().Set("Pragma", "no-cache")
-	resp.Header().Set("Expires", "0")
-	if err == nil {
-		_, _ = resp.Write(tile)
-	} else {
-		fmt.Printf("bigg error %v", err)
+	if err != nil {
+		log.Printf("could not read cached tile %s/%s/%s: %v", z, x, y, err)
+		return nil, false
 	}
-	return true
+	return tile, true
 }
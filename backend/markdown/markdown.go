@@ -0,0 +1,45 @@
+package markdown
+
+import (
+	"bytes"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// goldmark is safe by default: raw HTML is omitted and dangerous links (e.g. javascript:) are dropped
+var renderer = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+var tagMatcher = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+
+func Render(source string) string {
+	if source == "" {
+		return ""
+	}
+	result := bytes.Buffer{}
+	err := renderer.Convert([]byte(source), &result)
+	if err != nil {
+		log.Printf("could not render markdown: %v", err)
+		return ""
+	}
+	return result.String()
+}
+
+// Tags returns the lower-cased hashtags (e.g. "#long") contained in the text without duplicates
+func Tags(source string) []string {
+	result := make([]string, 0)
+	for _, match := range tagMatcher.FindAllStringSubmatch(source, -1) {
+		tag := strings.ToLower(match[1])
+		if !slices.Contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+)
+
+const (
+	gpsIfdPointerTag   = 0x8825
+	gpsLatitudeRefTag  = 0x0001
+	gpsLatitudeTag     = 0x0002
+	gpsLongitudeRefTag = 0x0003
+	gpsLongitudeTag    = 0x0004
+)
+
+type ifdEntry struct {
+	tag         uint16
+	count       uint32
+	valueOffset uint32
+	rawValue    []byte
+}
+
+// GpsCoordinates extracts the GPS position from the EXIF data of a JPEG image.
+// It returns nil if the image does not contain GPS information.
+func GpsCoordinates(content []byte) (*shared.Coordinates, error) {
+	tiff, err := findExifSegment(content)
+	if err != nil || tiff == nil {
+		return nil, err
+	}
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("exif header is too short")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unknown byte order in exif header")
+	}
+	ifd0, err := readIfd(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+	gpsPointer, ok := ifd0[gpsIfdPointerTag]
+	if !ok {
+		return nil, nil
+	}
+	gps, err := readIfd(tiff, order, gpsPointer.valueOffset)
+	if err != nil {
+		return nil, err
+	}
+	latitude, err := readDegrees(tiff, order, gps[gpsLatitudeTag], gps[gpsLatitudeRefTag], "S")
+	if err != nil {
+		return nil, err
+	}
+	longitude, err := readDegrees(tiff, order, gps[gpsLongitudeTag], gps[gpsLongitudeRefTag], "W")
+	if err != nil {
+		return nil, err
+	}
+	if latitude == nil || longitude == nil {
+		return nil, nil
+	}
+	return &shared.Coordinates{Latitude: *latitude, Longitude: *longitude}, nil
+}
+
+func findExifSegment(content []byte) ([]byte, error) {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != 0xD8 {
+		return nil, nil
+	}
+	position := 2
+	for position+4 <= len(content) {
+		if content[position] != 0xFF {
+			return nil, fmt.Errorf("invalid jpeg segment marker at %d", position)
+		}
+		marker := content[position+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan or end of image: no more metadata will follow
+			return nil, nil
+		}
+		length := int(binary.BigEndian.Uint16(content[position+2 : position+4]))
+		end := position + 2 + length
+		if length < 2 || end > len(content) {
+			return nil, fmt.Errorf("invalid jpeg segment length at %d", position)
+		}
+		payload := content[position+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:], nil
+		}
+		position = end
+	}
+	return nil, nil
+}
+
+func readIfd(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16]ifdEntry, error) {
+	if int(offset)+2 > len(tiff) {
+		return nil, fmt.Errorf("ifd offset %d out of range", offset)
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	result := make(map[uint16]ifdEntry)
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			return nil, fmt.Errorf("ifd entry %d out of range", i)
+		}
+		raw := tiff[start : start+12]
+		result[order.Uint16(raw[0:2])] = ifdEntry{
+			tag:         order.Uint16(raw[0:2]),
+			count:       order.Uint32(raw[4:8]),
+			valueOffset: order.Uint32(raw[8:12]),
+			rawValue:    raw[8:12],
+		}
+	}
+	return result, nil
+}
+
+func readDegrees(
+	tiff []byte, order binary.ByteOrder, value ifdEntry, reference ifdEntry, negativeReference string,
+) (*float64, error) {
+	if value.count != 3 {
+		return nil, nil
+	}
+	start := int(value.valueOffset)
+	if start+24 > len(tiff) {
+		return nil, fmt.Errorf("gps value out of range")
+	}
+	parts := [3]float64{}
+	for i := range parts {
+		numerator := order.Uint32(tiff[start+i*8 : start+i*8+4])
+		denominator := order.Uint32(tiff[start+i*8+4 : start+i*8+8])
+		if denominator == 0 {
+			return nil, nil
+		}
+		parts[i] = float64(numerator) / float64(denominator)
+	}
+	result := parts[0] + parts[1]/60 + parts[2]/3600
+	if reference.count > 0 && string(reference.rawValue[0:1]) == negativeReference {
+		result = -result
+	}
+	return &result, nil
+}
@@ -0,0 +1,80 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"slices"
+	"strings"
+)
+
+const thumbnailSize = 256
+
+// the MIME types of the images that can be decoded
+var decodableTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// IsImage tells whether the MIME type denotes an image that can be decoded
+func IsImage(mimeType string) bool {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	return slices.Contains(decodableTypes, strings.TrimSpace(mediaType))
+}
+
+// Dimensions reads the width and height of the image without decoding the whole image
+func Dimensions(reader io.Reader) (int, int, error) {
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not decode image header: %v", err)
+	}
+	return config.Width, config.Height, nil
+}
+
+func Thumbnail(content []byte) ([]byte, error) {
+	source, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %v", err)
+	}
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	scale := float64(thumbnailSize) / float64(max(width, height))
+	if scale > 1 {
+		scale = 1
+	}
+	targetWidth := max(1, int(float64(width)*scale))
+	targetHeight := max(1, int(float64(height)*scale))
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	// box filter: every target pixel is the average of the source pixels it covers
+	for y := 0; y < targetHeight; y++ {
+		sourceY0 := bounds.Min.Y + y*height/targetHeight
+		sourceY1 := max(sourceY0+1, bounds.Min.Y+(y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			sourceX0 := bounds.Min.X + x*width/targetWidth
+			sourceX1 := max(sourceX0+1, bounds.Min.X+(x+1)*width/targetWidth)
+			var r, g, b, a, count uint64
+			for sy := sourceY0; sy < sourceY1; sy++ {
+				for sx := sourceX0; sx < sourceX1; sx++ {
+					pr, pg, pb, pa := source.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			offset := target.PixOffset(x, y)
+			target.Pix[offset] = uint8(r / count >> 8)
+			target.Pix[offset+1] = uint8(g / count >> 8)
+			target.Pix[offset+2] = uint8(b / count >> 8)
+			target.Pix[offset+3] = uint8(a / count >> 8)
+		}
+	}
+	result := bytes.Buffer{}
+	err = jpeg.Encode(&result, target, &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode thumbnail: %v", err)
+	}
+	return result.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+const checkpointFile = "checkpoint.json"
+
+// checkpoint describes the source files the persisted projections were computed from
+type checkpoint struct {
+	Versions map[string]int                 `json:"versions"`
+	Files    map[string]checkpointFileState `json:"files"`
+}
+
+type checkpointFileState struct {
+	filebased.SourceFile
+	Hash string `json:"hash"`
+}
+
+func (p *Projection) readCheckpoint() checkpoint {
+	result := checkpoint{Versions: make(map[string]int), Files: make(map[string]checkpointFileState)}
+	content, err := os.ReadFile(filepath.Join(p.directory, checkpointFile))
+	if err != nil {
+		return result
+	}
+	_ = json.Unmarshal(content, &result)
+	return result
+}
+
+// scanSourceFiles determines the current state of the source files and whether they differ from the
+// previous state. Only files whose modification time or size changed are hashed.
+func (p *Projection) scanSourceFiles(previous map[string]checkpointFileState) (
+	map[string]checkpointFileState, bool, error,
+) {
+	files, err := p.fileService.SourceFiles()
+	if err != nil {
+		return nil, false, err
+	}
+	result := make(map[string]checkpointFileState, len(files))
+	changed := len(files) != len(previous)
+	for path, file := range files {
+		old, ok := previous[path]
+		if ok && old.SourceFile == file {
+			result[path] = old
+			continue
+		}
+		hash, err := p.fileService.HashSourceFile(path)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not hash %s: %v", path, err)
+		}
+		result[path] = checkpointFileState{SourceFile: file, Hash: hash}
+		changed = changed || !ok || old.Hash != hash
+	}
+	return result, changed, nil
+}
+
+// trackSourceFiles keeps the state of the source files up to date for the files touched by events
+func (p *Projection) trackSourceFiles() {
+	shared.Listen(
+		p.bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			p.updateSourceFiles(filebased.TrackSourceFiles(event.Id))
+		},
+	)
+	shared.Listen(
+		p.bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			p.updateSourceFiles(filebased.TrackSourceFiles(event.Id))
+		},
+	)
+	shared.Listen(
+		p.bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+			p.updateSourceFiles(filebased.JournalEntrySourceFiles(event.Id))
+		},
+	)
+	shared.Listen(
+		p.bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+			p.updateSourceFiles(filebased.JournalEntrySourceFiles(event.Id))
+		},
+	)
+}
+
+func (p *Projection) updateSourceFiles(paths []string) {
+	for _, path := range paths {
+		state := checkpointFileState{}
+		file, err := p.fileService.StatSourceFile(path)
+		if err == nil {
+			state.SourceFile = file
+			state.Hash, err = p.fileService.HashSourceFile(path)
+		}
+		p.mutex.Lock()
+		if err == nil {
+			p.files[path] = state
+		} else {
+			delete(p.files, path)
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// SaveCheckpoint records the state of the source files the projections match. Since the projections are
+// kept up to date by events while the app runs, it should be called on shutdown; if it is not (e.g. after
+// a crash), the projections are rebuilt at the next start. Files changed outside the app during the session
+// are recorded with their state at build time, thus they are detected at the next start.
+func (p *Projection) SaveCheckpoint() error {
+	if p.hasFailedWrites() {
+		return fmt.Errorf("not all projections could be written, they will be rebuilt at the next start")
+	}
+	p.mutex.Lock()
+	files := maps.Clone(p.files)
+	p.mutex.Unlock()
+	result := checkpoint{Versions: make(map[string]int), Files: files}
+	for _, projector := range p.projectors {
+		result.Versions[projector.ProjectionName()] = projector.Version()
+	}
+	payload, _ := json.Marshal(result)
+	err := os.WriteFile(filepath.Join(p.directory, checkpointFile), payload, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write checkpoint: %v", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package projection
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/filebased"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
@@ -12,17 +13,55 @@ import (
 )
 
 type Projection struct {
+	bus         *shared.EventBus
 	directory   string
 	projectors  []Projector
 	fileService *filebased.Service
+	// the state of the source files the projections match: the state at build time updated by the files
+	// touched by events; changes made outside the app are not included
+	files map[string]checkpointFileState
+	// the projections that could not be written; the checkpoint must not be saved as long as there are any
+	failedWrites map[string]bool
+	repairing    map[string]bool
+	// every projector listens on a bus of its own, to which the events are forwarded unless it is rebuilt
+	buses map[string]*projectorBus
+	mutex sync.Mutex
+}
+
+type projectorBus struct {
+	bus *shared.EventBus
+	// held for writing while the projector is rebuilt, thus events wait until it is done
+	gate sync.RWMutex
 }
 
 type Projector interface {
-	Init(message json.RawMessage, write func())
+	// Init loads the persisted data (nil if the projection is rebuilt) and subscribes to the events
+	Init(bus *shared.EventBus, message json.RawMessage, write func())
 	AddTrack(track shared.Track)
 	AddJournalEntry(entry shared.JournalEntry)
 	GetData() any
 	ProjectionName() string
+	// Version is the version of the projection's schema; it must be increased whenever the persisted data
+	// or the way it is computed changes
+	Version() int
+	// Reset discards all data of the projection without unregistering its event listeners
+	Reset()
+	// Fresh returns an empty instance with the same configuration that does not listen to any events
+	Fresh() Projector
+	// Swap takes over the data of the fresh instance, which is not used afterward
+	Swap(fresh Projector)
+}
+
+// failureReporter is implemented by projectors whose updates can fail for other reasons than writing
+// their data, the callback repairs the projection
+type failureReporter interface {
+	OnFailure(callback func())
+}
+
+// staleChecker is implemented by projectors that keep their data outside the persisted message, the
+// projection is rebuilt from the files if the data does not match the message
+type staleChecker interface {
+	Stale(message json.RawMessage) bool
 }
 
 type Retriever func() (json.RawMessage, error)
@@ -30,12 +69,16 @@ type Retriever func() (json.RawMessage, error)
 type Rebuilder func(message json.RawMessage) error
 
 func New(
-	configDirectory string, fileService *filebased.Service, projectors ...Projector,
+	bus *shared.EventBus, configDirectory string, fileService *filebased.Service, projectors ...Projector,
 ) *Projection {
 	result := &Projection{
-		directory:   configDirectory,
-		projectors:  projectors,
-		fileService: fileService,
+		bus:          bus,
+		directory:    configDirectory,
+		projectors:   projectors,
+		fileService:  fileService,
+		failedWrites: make(map[string]bool),
+		repairing:    make(map[string]bool),
+		buses:        make(map[string]*projectorBus),
 	}
 	return result
 }
@@ -45,37 +88,109 @@ func (p *Projection) Initialized() bool {
 	return err == nil
 }
 
+// Build initializes all projectors. A projection is loaded from disk if its schema version is the same
+// as during the last run and no track or journal file changed since the last checkpoint; otherwise it is
+// rebuilt from the files.
 func (p *Projection) Build() error {
-	err := os.RemoveAll(p.directory)
+	err := p.build()
+	if err != nil {
+		return err
+	}
+	p.trackSourceFiles()
+	// registered only now so that failures while building do not trigger a repair in the middle of the build
+	for _, projector := range p.projectors {
+		if reporter, ok := projector.(failureReporter); ok {
+			reporter.OnFailure(
+				func() {
+					p.repair(projector)
+				},
+			)
+		}
+	}
+	return nil
+}
+
+func (p *Projection) build() error {
+	err := os.MkdirAll(p.directory, 0755)
 	if err != nil {
 		return err
 	}
-	err = os.MkdirAll(p.directory, 0755)
+	previous := p.readCheckpoint()
+	current, sourcesChanged, err := p.scanSourceFiles(previous.Files)
 	if err != nil {
 		return err
 	}
+	// the checkpoint is only valid again after a clean shutdown, see SaveCheckpoint
+	_ = os.Remove(filepath.Join(p.directory, checkpointFile))
+	p.files = current
 
 	projectionsToRebuild := make([]Projector, 0)
-	for i := range p.projectors {
-		message, err := p.readFile(p.projectors[i].ProjectionName())
-		if err != nil {
-			projectionsToRebuild = append(projectionsToRebuild, p.projectors[i])
+	for _, projector := range p.projectors {
+		name := projector.ProjectionName()
+		message, err := p.readFile(name)
+		checker, ok := projector.(staleChecker)
+		stale := err == nil && ok && checker.Stale(message)
+		if err != nil || sourcesChanged || stale || previous.Versions[name] != projector.Version() {
+			if err == nil {
+				log.Printf("rebuilding projection %s", name)
+			}
+			projectionsToRebuild = append(projectionsToRebuild, projector)
 			message = nil
+			err = p.clear(name)
+			if err != nil {
+				return err
+			}
 		}
-		p.projectors[i].Init(
-			message, func() {
-				p.writePayload(p.projectors[i].GetData(), p.projectors[i].ProjectionName())
+		p.buses[name] = &projectorBus{bus: shared.NewEventBus()}
+		projector.Init(
+			p.buses[name].bus, message, func() {
+				p.writePayload(projector)
 			},
 		)
 	}
+	forward(p, shared.TrackUpsertedEvent{})
+	forward(p, shared.TrackDeletedEvent{})
+	forward(p, shared.JournalEntryUpsertedEvent{})
+	forward(p, shared.JournalEntryDeletedEvent{})
+	if len(projectionsToRebuild) == 0 {
+		return nil
+	}
+	err = p.feed(projectionsToRebuild)
+	if err != nil {
+		return err
+	}
+	for _, projector := range projectionsToRebuild {
+		p.writePayload(projector)
+	}
+	return nil
+}
+
+// forward delivers the events of the type to the buses of the projectors
+func forward[K any](p *Projection, event K) {
+	shared.Subscribe(
+		p.bus, event, func(k K) error {
+			errs := make([]error, 0)
+			for _, projector := range p.projectors {
+				target := p.buses[projector.ProjectionName()]
+				target.gate.RLock()
+				errs = append(errs, target.bus.Send(k))
+				target.gate.RUnlock()
+			}
+			return errors.Join(errs...)
+		},
+	)
+}
+
+// feed passes all tracks and journal entries to the projectors
+func (p *Projection) feed(projectors []Projector) error {
 	group := sync.WaitGroup{}
 	group.Add(2)
 	var tracksError error
 	go func() {
 		tracksError = p.fileService.ReadAllTracks(
 			func(track shared.Track) {
-				for i := range projectionsToRebuild {
-					p.projectors[i].AddTrack(track)
+				for _, projector := range projectors {
+					projector.AddTrack(track)
 				}
 			},
 		)
@@ -85,9 +200,9 @@ func (p *Projection) Build() error {
 	go func() {
 		var entries []shared.JournalEntry
 		entries, journalError = p.fileService.ReadAllJournalEntries()
-		for i := range projectionsToRebuild {
+		for _, projector := range projectors {
 			for j := range entries {
-				p.projectors[i].AddJournalEntry(entries[j])
+				projector.AddJournalEntry(entries[j])
 			}
 		}
 		group.Done()
@@ -101,20 +216,67 @@ func (p *Projection) Build() error {
 			return fmt.Errorf("could not read track entries: %v", tracksError)
 		}
 	}
-	for i := range p.projectors {
-		payload := p.projectors[i].GetData()
-		p.writePayload(payload, p.projectors[i].ProjectionName())
+	return nil
+}
+
+// Replay rebuilds all projections from the given tracks and journal entries instead of the files,
+// e.g. from the state recorded in the event log
+func (p *Projection) Replay(tracks []shared.Track, entries []shared.JournalEntry) {
+	for _, projector := range p.projectors {
+		projector.Reset()
+		for _, track := range tracks {
+			projector.AddTrack(track)
+		}
+		for _, entry := range entries {
+			projector.AddJournalEntry(entry)
+		}
+		p.writePayload(projector)
+	}
+	// the projections match the replayed state, which is not necessarily the state of the files
+	p.mutex.Lock()
+	p.files = make(map[string]checkpointFileState)
+	p.mutex.Unlock()
+}
+
+// clear removes the persisted data of the projection, i.e. its JSON file and its directory if there is one
+func (p *Projection) clear(name string) error {
+	err := os.Remove(filepath.Join(p.directory, name+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove projection %s: %v", name, err)
+	}
+	err = os.RemoveAll(filepath.Join(p.directory, name))
+	if err != nil {
+		return fmt.Errorf("could not remove projection directory %s: %v", name, err)
 	}
 	return nil
 }
 
-func (p *Projection) writePayload(payload any, name string) {
-	message, _ := json.Marshal(payload)
+// writePayload persists the projector's data. If that fails, the outdated file is removed so that the
+// projection is rebuilt at the next start, and the projection is repaired in the background.
+func (p *Projection) writePayload(projector Projector) {
+	name := projector.ProjectionName()
+	message, _ := json.Marshal(projector.GetData())
 	err := p.writeProjection(message, name)
-	if err != nil {
-		log.Printf("%v", fmt.Errorf("could not write projection %s: %v", name, err))
-		//TODO delete file in order to reattempt writing the next time
+	p.mutex.Lock()
+	p.failedWrites[name] = err != nil
+	p.mutex.Unlock()
+	if err == nil {
+		return
+	}
+	log.Printf("%v", fmt.Errorf("could not write projection %s: %v", name, err))
+	_ = os.Remove(filepath.Join(p.directory, name+".json"))
+	go p.repair(projector)
+}
+
+func (p *Projection) hasFailedWrites() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, failed := range p.failedWrites {
+		if failed {
+			return true
+		}
 	}
+	return false
 }
 
 func (p *Projection) writeProjection(message json.RawMessage, name string) error {
@@ -0,0 +1,314 @@
+package projection
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/private-running-journal/backend/markdown"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"html"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	SearchHitTrack        = "track"
+	SearchHitJournalEntry = "journalEntry"
+)
+
+const snippetRadius = 60
+
+var searchFieldWeights = map[string]float64{"name": 3, "tags": 2, "comment": 1}
+
+type SearchHit struct {
+	Kind    string  `json:"kind"`
+	Id      string  `json:"id"`
+	Title   string  `json:"title"`
+	Date    string  `json:"date"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type searchDocument struct {
+	Kind    string   `json:"kind"`
+	Id      string   `json:"id"`
+	Name    string   `json:"name"`
+	Comment string   `json:"comment"`
+	Tags    []string `json:"tags"`
+	Date    string   `json:"date"`
+	TrackId string   `json:"trackId"`
+}
+
+type SearchIndex struct {
+	sync.RWMutex
+	documents map[string]searchDocument
+	postings  map[string]map[string]float64
+}
+
+func (s *SearchIndex) ProjectionName() string {
+	return "searchIndex"
+}
+
+func (s *SearchIndex) Version() int {
+	return 1
+}
+
+func (s *SearchIndex) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	s.Reset()
+	if message != nil {
+		documents := make(map[string]searchDocument)
+		_ = json.Unmarshal(message, &documents)
+		for _, document := range documents {
+			s.upsert(document)
+		}
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			s.Lock()
+			s.upsert(trackDocument(event.Id, event.Name, event.Comment))
+			s.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			s.Lock()
+			s.remove(SearchHitTrack + ":" + event.Id)
+			s.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+			s.Lock()
+			s.upsert(journalEntryDocument(*event.JournalEntry))
+			s.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+			s.Lock()
+			s.remove(SearchHitJournalEntry + ":" + event.Id)
+			s.Unlock()
+			writer()
+		},
+	)
+}
+
+func (s *SearchIndex) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.documents = make(map[string]searchDocument)
+	s.postings = make(map[string]map[string]float64)
+}
+
+func (s *SearchIndex) Fresh() Projector {
+	result := &SearchIndex{}
+	result.Reset()
+	return result
+}
+
+func (s *SearchIndex) Swap(fresh Projector) {
+	other := fresh.(*SearchIndex)
+	s.Lock()
+	defer s.Unlock()
+	s.documents = other.documents
+	s.postings = other.postings
+}
+
+func (s *SearchIndex) AddTrack(track shared.Track) {
+	s.Lock()
+	defer s.Unlock()
+	s.upsert(trackDocument(track.Id, track.Name, track.Comment))
+}
+
+func (s *SearchIndex) AddJournalEntry(entry shared.JournalEntry) {
+	s.Lock()
+	defer s.Unlock()
+	s.upsert(journalEntryDocument(entry))
+}
+
+func (s *SearchIndex) GetData() any {
+	s.RLock()
+	defer s.RUnlock()
+	return s.documents
+}
+
+func trackDocument(id string, name string, comment string) searchDocument {
+	return searchDocument{
+		Kind:    SearchHitTrack,
+		Id:      id,
+		Name:    name,
+		Comment: comment,
+		Tags:    markdown.Tags(comment),
+	}
+}
+
+func journalEntryDocument(entry shared.JournalEntry) searchDocument {
+	name := ""
+	if entry.EmbeddedTrack != nil {
+		name = entry.EmbeddedTrack.Name
+	}
+	return searchDocument{
+		Name:    name,
+		Kind:    SearchHitJournalEntry,
+		Id:      entry.Id,
+		Comment: entry.Comment,
+		Tags:    markdown.Tags(entry.Comment),
+		Date:    entry.Date.Format(time.DateOnly),
+		TrackId: entry.TrackId,
+	}
+}
+
+func (d searchDocument) key() string {
+	return d.Kind + ":" + d.Id
+}
+
+func (s *SearchIndex) upsert(document searchDocument) {
+	key := document.key()
+	s.remove(key)
+	s.documents[key] = document
+	fields := map[string]string{
+		"name":    document.Name,
+		"tags":    strings.Join(document.Tags, " "),
+		"comment": document.Comment,
+	}
+	for field, text := range fields {
+		for _, token := range tokenize(text) {
+			if _, ok := s.postings[token]; !ok {
+				s.postings[token] = make(map[string]float64)
+			}
+			s.postings[token][key] = s.postings[token][key] + searchFieldWeights[field]
+		}
+	}
+}
+
+func (s *SearchIndex) remove(key string) {
+	document, ok := s.documents[key]
+	if !ok {
+		return
+	}
+	delete(s.documents, key)
+	tokens := tokenize(document.Name + " " + strings.Join(document.Tags, " ") + " " + document.Comment)
+	for _, token := range tokens {
+		delete(s.postings[token], key)
+		if len(s.postings[token]) == 0 {
+			delete(s.postings, token)
+		}
+	}
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(
+		strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		},
+	)
+}
+
+// Search ranks the documents by a tf-idf score. The last token of the query is also matched as prefix
+// so that results can be shown while typing.
+func (s *SearchIndex) Search(query string) []SearchHit {
+	s.RLock()
+	defer s.RUnlock()
+	tokens := tokenize(query)
+	scores := make(map[string]float64)
+	matchedTokens := make(map[string][]string)
+	for index, queryToken := range tokens {
+		candidates := []string{queryToken}
+		if index == len(tokens)-1 {
+			candidates = make([]string, 0)
+			for token := range s.postings {
+				if strings.HasPrefix(token, queryToken) {
+					candidates = append(candidates, token)
+				}
+			}
+		}
+		for _, token := range candidates {
+			postings := s.postings[token]
+			idf := math.Log(1 + float64(len(s.documents))/float64(len(postings)))
+			for key, weight := range postings {
+				scores[key] = scores[key] + weight*idf
+				matchedTokens[key] = append(matchedTokens[key], token)
+			}
+		}
+	}
+	result := make([]SearchHit, 0, len(scores))
+	for key, score := range scores {
+		document := s.documents[key]
+		title := document.Name
+		if document.Kind == SearchHitJournalEntry && document.TrackId != "" {
+			title = s.documents[SearchHitTrack+":"+document.TrackId].Name
+		}
+		result = append(
+			result, SearchHit{
+				Kind:    document.Kind,
+				Id:      document.Id,
+				Title:   title,
+				Date:    document.Date,
+				Score:   score,
+				Snippet: snippet(document.Comment, matchedTokens[key]),
+			},
+		)
+	}
+	slices.SortFunc(
+		result, func(a, b SearchHit) int {
+			if a.Score != b.Score {
+				return int(math.Copysign(1, b.Score-a.Score))
+			}
+			return strings.Compare(b.Date, a.Date)
+		},
+	)
+	return result
+}
+
+// snippet returns an excerpt of the text around the first matched token. The excerpt is HTML-escaped
+// and the matched tokens are wrapped in <mark> elements.
+func snippet(text string, tokens []string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, token := range tokens {
+		tokenRunes := []rune(token)
+		for i := 0; i+len(tokenRunes) <= len(lower) && len(tokenRunes) > 0; i++ {
+			if !slices.Equal(lower[i:i+len(tokenRunes)], tokenRunes) {
+				continue
+			}
+			for j := i; j < i+len(tokenRunes); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+	start, end := 0, min(len(runes), 2*snippetRadius)
+	if first >= 0 {
+		start = max(0, first-snippetRadius)
+		end = min(len(runes), first+snippetRadius)
+	}
+	result := strings.Builder{}
+	if start > 0 {
+		result.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			result.WriteString("<mark>")
+		}
+		result.WriteString(html.EscapeString(string(runes[i])))
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			result.WriteString("</mark>")
+		}
+	}
+	if end < len(runes) {
+		result.WriteString("…")
+	}
+	return result.String()
+}
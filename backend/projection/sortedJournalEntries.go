@@ -2,132 +2,155 @@ package projection
 
 import (
 	"encoding/json"
-	"fmt"
-	"github.com/fafeitsch/private-running-journal/backend/filebased"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
-	"log"
-	"os"
-	"path/filepath"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
-const sortedJournalEntriesDirectory = "sortedJournalEntries"
+type sortedJournalEntry struct {
+	date time.Time
+	id   string
+}
 
+// SortedJournalEntries keeps the ids of all journal entries sorted by date. It is persisted as
+// a map from the date to the ids of the entries on this date.
 type SortedJournalEntries struct {
-	fileService *filebased.Service
-	Directory   string
+	sync.RWMutex
+	entries []sortedJournalEntry
+	dates   map[string]time.Time
 }
 
 func (s *SortedJournalEntries) ProjectionName() string {
 	return "sortedJournalEntries"
 }
 
-func (s *SortedJournalEntries) Init(message json.RawMessage, writer func()) {
+func (s *SortedJournalEntries) Version() int {
+	return 2
+}
+
+func (s *SortedJournalEntries) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	s.Reset()
+	if message != nil {
+		content := make(map[string][]string)
+		_ = json.Unmarshal(message, &content)
+		for day, ids := range content {
+			date, err := time.Parse(time.DateOnly, day)
+			if err != nil {
+				continue
+			}
+			for _, id := range ids {
+				s.dates[id] = date
+				s.entries = append(s.entries, sortedJournalEntry{date: date, id: id})
+			}
+		}
+		slices.SortFunc(s.entries, compareSortedJournalEntries)
+	}
 	shared.Listen(
-		shared.JournalEntryUpsertedEvent{}, func(k shared.JournalEntryUpsertedEvent) {
-			s.handleUpsertEvent(k)
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+			s.upsert(event.Id, event.Date)
+			writer()
 		},
 	)
 	shared.Listen(
-		shared.JournalEntryDeletedEvent{}, func(k shared.JournalEntryDeletedEvent) {
-			s.handleDeleteEvent(k.Date, k.Id)
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+			s.Lock()
+			s.remove(event.Id)
+			s.Unlock()
+			writer()
 		},
 	)
 }
 
-func (s *SortedJournalEntries) AddTrack(track shared.Track) {
+func (s *SortedJournalEntries) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.entries = make([]sortedJournalEntry, 0)
+	s.dates = make(map[string]time.Time)
 }
 
-func (s *SortedJournalEntries) AddJournalEntry(entry shared.JournalEntry) {
-	s.handleUpsertEvent(
-		shared.JournalEntryUpsertedEvent{
-			JournalEntry: &entry,
-			OldTrackId:   "",
-			OldDate:      nil,
-		},
-	)
+func (s *SortedJournalEntries) Fresh() Projector {
+	result := &SortedJournalEntries{}
+	result.Reset()
+	return result
 }
 
-func (s *SortedJournalEntries) Get() map[string]string {
-	return make(map[string]string)
+func (s *SortedJournalEntries) Swap(fresh Projector) {
+	other := fresh.(*SortedJournalEntries)
+	s.Lock()
+	defer s.Unlock()
+	s.entries = other.entries
+	s.dates = other.dates
 }
 
-func (s *SortedJournalEntries) GetData() any {
-	return make(map[string]string)
+func (s *SortedJournalEntries) AddTrack(track shared.Track) {
 }
 
-func (s *SortedJournalEntries) handleDeleteEvent(date time.Time, id string) {
-	split := strings.Split(date.Format(time.DateOnly), "-")
-	path := filepath.Join(s.Directory, ".projection", sortedJournalEntriesDirectory, split[0], split[1], split[2], id)
-	err := os.Remove(path)
-	if err != nil {
-		log.Printf("error removing file %s: %s", path, err)
+func (s *SortedJournalEntries) AddJournalEntry(entry shared.JournalEntry) {
+	s.upsert(entry.Id, entry.Date)
+}
+
+// GetData returns the ids of the journal entries per date
+func (s *SortedJournalEntries) GetData() any {
+	s.RLock()
+	defer s.RUnlock()
+	result := make(map[string][]string)
+	for _, entry := range s.entries {
+		day := entry.date.Format(time.DateOnly)
+		result[day] = append(result[day], entry.id)
 	}
+	return result
 }
 
-func (s *SortedJournalEntries) handleUpsertEvent(event shared.JournalEntryUpsertedEvent) {
-	if event.OldDate != nil {
-		s.handleDeleteEvent(event.Date, event.Id)
+func (s *SortedJournalEntries) upsert(id string, date time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.remove(id)
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	entry := sortedJournalEntry{date: date, id: id}
+	index, _ := slices.BinarySearchFunc(s.entries, entry, compareSortedJournalEntries)
+	s.entries = slices.Insert(s.entries, index, entry)
+	s.dates[id] = date
+}
+
+// remove must only be called while holding the lock
+func (s *SortedJournalEntries) remove(id string) {
+	date, ok := s.dates[id]
+	if !ok {
+		return
 	}
-	split := strings.Split(event.Date.Format(time.DateOnly), "-")
-	path := filepath.Join(s.Directory, ".projection", sortedJournalEntriesDirectory, split[0], split[1], split[2])
-	os.MkdirAll(path, 0755)
-	err := os.Symlink(
-		filepath.Join("..", "..", "..", "..", "..", "journal", event.Id[0:2], event.Id),
-		filepath.Join(path, event.Id),
+	index, found := slices.BinarySearchFunc(
+		s.entries, sortedJournalEntry{date: date, id: id}, compareSortedJournalEntries,
 	)
-	if err != nil {
-		log.Printf("could not link journal entry: %v", err)
+	if found {
+		s.entries = slices.Delete(s.entries, index, index+1)
 	}
+	delete(s.dates, id)
 }
 
-var yearMatcher = regexp.MustCompile(`^\d{4}$`)
-var monthMatcher = regexp.MustCompile(`^\d{2}$`)
-var dayMatcher = regexp.MustCompile(`^\d{2}$`)
+func compareSortedJournalEntries(a sortedJournalEntry, b sortedJournalEntry) int {
+	if compare := a.date.Compare(b.date); compare != 0 {
+		return compare
+	}
+	return strings.Compare(a.id, b.id)
+}
 
+// FindJournalEntryIdsBetween returns the ids of the journal entries dated on start or after start and before end
 func (s *SortedJournalEntries) FindJournalEntryIdsBetween(start time.Time, end time.Time) ([]string, error) {
-	result := make([]string, 0, 0)
-	walkPath := filepath.Join(s.Directory, ".projection", sortedJournalEntriesDirectory)
-	err := filepath.Walk(
-		walkPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Printf("skipping directory \"%s\" because an error occurred: %v", path, err)
-				return filepath.SkipDir
-			}
-			parts := strings.Split(path, string(os.PathSeparator))
-			partsLength := len(parts)
-			if path == walkPath {
-				return nil
-			}
-			if yearMatcher.MatchString(parts[partsLength-1]) {
-				// we are on level one: years
-				year, _ := strconv.Atoi(parts[partsLength-1])
-				if year >= start.Year() && year <= end.Year() {
-					return nil
-				}
-			} else if yearMatcher.MatchString(parts[partsLength-2]) && monthMatcher.MatchString(parts[partsLength-1]) {
-				// we are on level three: months (no performance improvement here)
-				return nil
-			} else if yearMatcher.MatchString(parts[partsLength-3]) && monthMatcher.MatchString(parts[partsLength-2]) && dayMatcher.MatchString(parts[partsLength-1]) {
-				// we are on level three: days
-				year := parts[partsLength-3]
-				month := parts[partsLength-2]
-				day := parts[partsLength-1][:2]
-				date, _ := time.Parse(time.DateOnly, fmt.Sprintf("%s-%s-%s", year, month, day))
-				if date.Equal(start) || (date.After(start) && date.Before(end)) {
-					return nil
-				} else {
-					return filepath.SkipDir
-				}
-			} else if yearMatcher.MatchString(parts[partsLength-4]) && monthMatcher.MatchString(parts[partsLength-3]) && dayMatcher.MatchString(parts[partsLength-2]) {
-				// below the days-level: this must be the id
-				result = append(result, filepath.Base(path))
-			}
-			return nil
+	s.RLock()
+	defer s.RUnlock()
+	result := make([]string, 0)
+	index, _ := slices.BinarySearchFunc(
+		s.entries, start, func(entry sortedJournalEntry, start time.Time) int {
+			return entry.date.Compare(start)
 		},
 	)
-	return result, err
+	for _, entry := range s.entries[index:] {
+		if !entry.date.Equal(start) && !entry.date.Before(end) {
+			break
+		}
+		result = append(result, entry.id)
+	}
+	return result, nil
 }
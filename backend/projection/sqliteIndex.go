@@ -0,0 +1,423 @@
+package projection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sqliteIndexFile = "index.sqlite"
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS tracks (id TEXT PRIMARY KEY, name TEXT NOT NULL, parents TEXT NOT NULL, length INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS entries (
+		id TEXT PRIMARY KEY, track_id TEXT NOT NULL, date TEXT NOT NULL, laps INTEGER NOT NULL,
+		custom_length INTEGER, duration INTEGER NOT NULL, temperature REAL
+	)`,
+	`CREATE INDEX IF NOT EXISTS entries_date ON entries (date)`,
+	`CREATE INDEX IF NOT EXISTS entries_track ON entries (track_id)`,
+}
+
+// IndexedTrack and IndexedEntry are the rows of the SQLite index
+type IndexedTrack struct {
+	Name    string   `json:"name"`
+	Parents []string `json:"parents"`
+	Length  int      `json:"length"`
+}
+
+type IndexedEntry struct {
+	Id           string    `json:"-"`
+	TrackId      string    `json:"trackId"`
+	Date         time.Time `json:"date"`
+	Laps         int       `json:"laps"`
+	CustomLength *int      `json:"customLength"`
+	// the duration in seconds, zero if unknown
+	Duration    int      `json:"duration"`
+	Temperature *float64 `json:"temperature"`
+	// the following fields are only set by queries joining the track
+	TrackName    string `json:"-"`
+	TrackMissing bool   `json:"-"`
+	Length       int    `json:"-"`
+}
+
+// sqliteIndexMarker is persisted instead of the data in order to detect a lost or outdated database
+type sqliteIndexMarker struct {
+	SchemaVersion int `json:"schemaVersion"`
+	Tracks        int `json:"tracks"`
+	Entries       int `json:"entries"`
+}
+
+// SqliteIndex keeps tracks and journal entries in an SQLite database in order to answer list and dashboard
+// queries without reading the entry and GPX files. The files stay the source of truth; the database is
+// rebuilt from them like every other projection.
+type SqliteIndex struct {
+	Directory string
+	db        *sql.DB
+	onFailure func()
+}
+
+func (s *SqliteIndex) ProjectionName() string {
+	return "sqliteIndex"
+}
+
+func (s *SqliteIndex) Version() int {
+	return 2
+}
+
+func (s *SqliteIndex) OnFailure(callback func()) {
+	s.onFailure = callback
+}
+
+func (s *SqliteIndex) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	err := s.openOrRecreate()
+	if err != nil {
+		log.Printf("could not create sqlite index: %v", err)
+		return
+	}
+	if message == nil {
+		s.Reset()
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			s.check(
+				s.upsertTrack(
+					event.Id, IndexedTrack{Name: event.Name, Parents: event.Parents, Length: event.Waypoints.Length()},
+				),
+			)
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			s.check(s.exec(`DELETE FROM tracks WHERE id = ?`, event.Id))
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+			s.check(s.upsertEntry(indexedEntryOf(*event.JournalEntry)))
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+			s.check(s.exec(`DELETE FROM entries WHERE id = ?`, event.Id))
+		},
+	)
+}
+
+// Stale reports whether the database was lost or does not match the marker, the index is rebuilt from the
+// files then
+func (s *SqliteIndex) Stale(message json.RawMessage) bool {
+	marker := sqliteIndexMarker{}
+	err := json.Unmarshal(message, &marker)
+	if err != nil || marker.SchemaVersion != s.Version() {
+		return true
+	}
+	if _, err = os.Stat(s.path()); err != nil {
+		return true
+	}
+	err = s.openOrRecreate()
+	if err != nil {
+		return true
+	}
+	current, err := s.marker()
+	return err != nil || current != marker
+}
+
+func (s *SqliteIndex) path() string {
+	return filepath.Join(s.Directory, ".projection", sqliteIndexFile)
+}
+
+func (s *SqliteIndex) openOrRecreate() error {
+	if s.db != nil {
+		return nil
+	}
+	path := s.path()
+	_ = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	err := s.open(path)
+	if err != nil {
+		log.Printf("could not open sqlite index, recreating it: %v", err)
+		_ = os.Remove(path)
+		err = s.open(path)
+	}
+	return err
+}
+
+func (s *SqliteIndex) open(path string) error {
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(OFF)")
+	if err != nil {
+		return err
+	}
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+	for _, statement := range sqliteSchema {
+		_, err = db.Exec(statement)
+		if err != nil {
+			_ = db.Close()
+			return err
+		}
+	}
+	s.db = db
+	return nil
+}
+
+func (s *SqliteIndex) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *SqliteIndex) check(err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("could not update sqlite index: %v", err)
+	if s.onFailure != nil {
+		s.onFailure()
+	}
+}
+
+func (s *SqliteIndex) exec(statement string, args ...any) error {
+	if s.db == nil {
+		return fmt.Errorf("the sqlite index is not available")
+	}
+	_, err := s.db.Exec(statement, args...)
+	return err
+}
+
+func (s *SqliteIndex) Reset() {
+	s.check(s.exec(`DELETE FROM tracks`))
+	s.check(s.exec(`DELETE FROM entries`))
+}
+
+// Fresh returns an index kept in memory
+func (s *SqliteIndex) Fresh() Projector {
+	result := &SqliteIndex{Directory: s.Directory}
+	err := result.open(":memory:")
+	if err != nil {
+		log.Printf("could not create sqlite index in memory: %v", err)
+	}
+	return result
+}
+
+// Swap replaces all rows by the rows of the fresh index in one transaction and closes the fresh index
+func (s *SqliteIndex) Swap(fresh Projector) {
+	other := fresh.(*SqliteIndex)
+	defer other.Close()
+	s.check(s.copyRows(other))
+}
+
+func (s *SqliteIndex) copyRows(other *SqliteIndex) error {
+	tracks, err := other.queryTracks(``)
+	if err != nil {
+		return err
+	}
+	entries, err := other.queryEntries(`WHERE 1 = 1`)
+	if err != nil {
+		return err
+	}
+	if s.db == nil {
+		return fmt.Errorf("the sqlite index is not available")
+	}
+	transaction, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer transaction.Rollback()
+	_, err = transaction.Exec(`DELETE FROM tracks`)
+	if err == nil {
+		_, err = transaction.Exec(`DELETE FROM entries`)
+	}
+	for id, track := range tracks {
+		if err == nil {
+			_, err = transaction.Exec(upsertTrackStatement, trackArguments(id, track)...)
+		}
+	}
+	for _, entry := range entries {
+		if err == nil {
+			_, err = transaction.Exec(upsertEntryStatement, entryArguments(entry)...)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return transaction.Commit()
+}
+
+func (s *SqliteIndex) AddTrack(track shared.Track) {
+	s.check(
+		s.upsertTrack(track.Id, IndexedTrack{Name: track.Name, Parents: track.Parents, Length: track.Waypoints.Length()}),
+	)
+}
+
+func (s *SqliteIndex) AddJournalEntry(entry shared.JournalEntry) {
+	s.check(s.upsertEntry(indexedEntryOf(entry)))
+}
+
+// GetData returns a marker of the index; the data itself is only kept in the database
+func (s *SqliteIndex) GetData() any {
+	result, err := s.marker()
+	if err != nil {
+		log.Printf("could not count rows of sqlite index: %v", err)
+	}
+	return result
+}
+
+func (s *SqliteIndex) marker() (sqliteIndexMarker, error) {
+	result := sqliteIndexMarker{SchemaVersion: s.Version()}
+	if s.db == nil {
+		return result, fmt.Errorf("the sqlite index is not available")
+	}
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM tracks), (SELECT COUNT(*) FROM entries)`).Scan(
+		&result.Tracks, &result.Entries,
+	)
+	return result, err
+}
+
+func indexedEntryOf(entry shared.JournalEntry) IndexedEntry {
+	duration, _ := entry.Duration()
+	var temperature *float64
+	if entry.Weather != nil {
+		temperature = &entry.Weather.Temperature
+	}
+	return IndexedEntry{
+		Id:           entry.Id,
+		TrackId:      entry.TrackId,
+		Date:         entry.Date,
+		Laps:         entry.Laps,
+		CustomLength: entry.CustomLength,
+		Duration:     int(duration.Seconds()),
+		Temperature:  temperature,
+	}
+}
+
+const upsertTrackStatement = `INSERT INTO tracks (id, name, parents, length) VALUES (?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET name = excluded.name, parents = excluded.parents, length = excluded.length`
+
+const upsertEntryStatement = `INSERT INTO entries (id, track_id, date, laps, custom_length, duration, temperature)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET track_id = excluded.track_id, date = excluded.date, laps = excluded.laps,
+	custom_length = excluded.custom_length, duration = excluded.duration, temperature = excluded.temperature`
+
+func trackArguments(id string, track IndexedTrack) []any {
+	parents, _ := json.Marshal(track.Parents)
+	return []any{id, track.Name, string(parents), track.Length}
+}
+
+func entryArguments(entry IndexedEntry) []any {
+	return []any{
+		entry.Id, entry.TrackId, entry.Date.Format(time.DateOnly), entry.Laps, entry.CustomLength, entry.Duration,
+		entry.Temperature,
+	}
+}
+
+func (s *SqliteIndex) upsertTrack(id string, track IndexedTrack) error {
+	return s.exec(upsertTrackStatement, trackArguments(id, track)...)
+}
+
+func (s *SqliteIndex) upsertEntry(entry IndexedEntry) error {
+	return s.exec(upsertEntryStatement, entryArguments(entry)...)
+}
+
+// FindEntriesBetween returns the entries from start (inclusive) to end (exclusive), sorted by date, together
+// with the name of their tracks and their lengths
+func (s *SqliteIndex) FindEntriesBetween(start time.Time, end time.Time) ([]IndexedEntry, error) {
+	return s.queryEntries(
+		`WHERE e.date >= ? AND e.date < ?`, firstDayFrom(start).Format(time.DateOnly),
+		firstDayFrom(end).Format(time.DateOnly),
+	)
+}
+
+// firstDayFrom returns the first day (as UTC midnight) not before the given time
+func firstDayFrom(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if day.Before(t) {
+		return day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+func (s *SqliteIndex) queryEntries(condition string, args ...any) ([]IndexedEntry, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("the sqlite index is not available")
+	}
+	rows, err := s.db.Query(
+		`SELECT e.id, e.track_id, e.date, e.laps, e.custom_length, e.duration, e.temperature, t.name, t.length
+		FROM entries e LEFT JOIN tracks t ON t.id = e.track_id `+condition+` ORDER BY e.date, e.id`, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite index: %v", err)
+	}
+	defer rows.Close()
+	result := make([]IndexedEntry, 0)
+	for rows.Next() {
+		entry := IndexedEntry{}
+		var date string
+		var customLength, trackLength sql.NullInt64
+		var temperature sql.NullFloat64
+		var trackName sql.NullString
+		err = rows.Scan(
+			&entry.Id, &entry.TrackId, &date, &entry.Laps, &customLength, &entry.Duration, &temperature, &trackName,
+			&trackLength,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row of sqlite index: %v", err)
+		}
+		entry.Date, _ = time.Parse(time.DateOnly, date)
+		entry.TrackName = trackName.String
+		entry.TrackMissing = !trackName.Valid
+		entry.Length = int(trackLength.Int64) * entry.Laps
+		if customLength.Valid {
+			value := int(customLength.Int64)
+			entry.CustomLength = &value
+			entry.Length = value
+		}
+		if temperature.Valid {
+			entry.Temperature = &temperature.Float64
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
+
+// FindTracks returns the indexed tracks with the given ids
+func (s *SqliteIndex) FindTracks(ids []string) (map[string]IndexedTrack, error) {
+	if len(ids) == 0 {
+		return make(map[string]IndexedTrack), nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return s.queryTracks(`WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+}
+
+func (s *SqliteIndex) queryTracks(condition string, args ...any) (map[string]IndexedTrack, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("the sqlite index is not available")
+	}
+	rows, err := s.db.Query(`SELECT id, name, parents, length FROM tracks `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sqlite index: %v", err)
+	}
+	defer rows.Close()
+	result := make(map[string]IndexedTrack)
+	for rows.Next() {
+		var id, parents string
+		track := IndexedTrack{}
+		err = rows.Scan(&id, &track.Name, &parents, &track.Length)
+		if err != nil {
+			return nil, fmt.Errorf("could not read row of sqlite index: %v", err)
+		}
+		_ = json.Unmarshal([]byte(parents), &track.Parents)
+		result[id] = track
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,313 @@
+package projection
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/spatial"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// the grid cells are 0.01 degrees wide and high, i.e. about 1 km in latitude
+const locationCellSize = 0.01
+
+// waypoints are densified to this spacing (in meters) before indexing, so that no cell crossed by a
+// long segment is missed
+const locationIndexSpacing = 250
+
+// nearest track searches give up after this many rings of cells around the coordinate
+const maxNearestTrackRings = 50
+
+// searches for tracks starting near a coordinate are limited to this radius (in meters)
+const maxStartRadius = 50000
+
+type TrackLocation struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Length   int    `json:"length"`
+	Distance int    `json:"distance"`
+}
+
+type locationCell struct {
+	x int
+	y int
+}
+
+type trackLocationEntry struct {
+	Name      string           `json:"name"`
+	Waypoints shared.Waypoints `json:"waypoints"`
+	// computed when the track is inserted, thus it is not recomputed for every search result
+	length int
+}
+
+// TrackLocations is a spatial index over the waypoints of all tracks. Every track is registered in each grid
+// cell its line passes through. The starting points are kept in a separate index.
+type TrackLocations struct {
+	sync.RWMutex
+	tracks map[string]trackLocationEntry
+	cells  map[locationCell][]string
+	starts *spatial.Index
+}
+
+func (t *TrackLocations) ProjectionName() string {
+	return "trackLocations"
+}
+
+func (t *TrackLocations) Version() int {
+	return 1
+}
+
+func (t *TrackLocations) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	t.Reset()
+	if message != nil {
+		tracks := make(map[string]trackLocationEntry)
+		_ = json.Unmarshal(message, &tracks)
+		for id, track := range tracks {
+			t.upsert(id, track)
+		}
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			t.Lock()
+			t.upsert(event.Id, trackLocationEntry{Name: event.Name, Waypoints: event.Waypoints})
+			t.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			t.Lock()
+			t.remove(event.Id)
+			t.Unlock()
+			writer()
+		},
+	)
+}
+
+func (t *TrackLocations) Reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.tracks = make(map[string]trackLocationEntry)
+	t.cells = make(map[locationCell][]string)
+	t.starts = spatial.NewIndex(locationCellSize)
+}
+
+func (t *TrackLocations) Fresh() Projector {
+	result := &TrackLocations{}
+	result.Reset()
+	return result
+}
+
+func (t *TrackLocations) Swap(fresh Projector) {
+	other := fresh.(*TrackLocations)
+	t.Lock()
+	defer t.Unlock()
+	t.tracks = other.tracks
+	t.cells = other.cells
+	t.starts = other.starts
+}
+
+func (t *TrackLocations) AddTrack(track shared.Track) {
+	t.Lock()
+	defer t.Unlock()
+	t.upsert(track.Id, trackLocationEntry{Name: track.Name, Waypoints: track.Waypoints})
+}
+
+func (t *TrackLocations) AddJournalEntry(entry shared.JournalEntry) {}
+
+func (t *TrackLocations) GetData() any {
+	t.RLock()
+	defer t.RUnlock()
+	return t.tracks
+}
+
+func (t *TrackLocations) upsert(id string, track trackLocationEntry) {
+	t.remove(id)
+	track.length = track.Waypoints.Length()
+	t.tracks[id] = track
+	for _, c := range cellsOf(track.Waypoints) {
+		t.cells[c] = append(t.cells[c], id)
+	}
+	if len(track.Waypoints) > 0 {
+		start := track.Waypoints[0]
+		t.starts.Insert(id, shared.Waypoints{start}.BoundingBox())
+	}
+}
+
+func (t *TrackLocations) remove(id string) {
+	track, ok := t.tracks[id]
+	if !ok {
+		return
+	}
+	delete(t.tracks, id)
+	for _, c := range cellsOf(track.Waypoints) {
+		t.cells[c] = slices.DeleteFunc(
+			t.cells[c], func(s string) bool {
+				return s == id
+			},
+		)
+		if len(t.cells[c]) == 0 {
+			delete(t.cells, c)
+		}
+	}
+	t.starts.Remove(id)
+}
+
+// densifyOrRaw falls back to the raw waypoints if the waypoints cannot be densified
+func densifyOrRaw(waypoints shared.Waypoints, spacing float64) shared.Waypoints {
+	densified, err := waypoints.Densify(spacing)
+	if err != nil {
+		log.Printf("could not densify waypoints, using the raw waypoints: %v", err)
+		return waypoints
+	}
+	return densified
+}
+
+func cellsOf(waypoints shared.Waypoints) []locationCell {
+	densified := densifyOrRaw(waypoints, locationIndexSpacing)
+	result := make([]locationCell, 0)
+	for _, waypoint := range densified {
+		c := cellOf(waypoint)
+		if !slices.Contains(result, c) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func cellOf(c shared.Coordinates) locationCell {
+	return locationCell{
+		x: int(math.Floor(c.Longitude / locationCellSize)), y: int(math.Floor(c.Latitude / locationCellSize)),
+	}
+}
+
+// FindInArea returns all tracks with at least one segment passing through the bounding box
+func (t *TrackLocations) FindInArea(box shared.BoundingBox) []TrackLocation {
+	t.RLock()
+	defer t.RUnlock()
+	lowerLeft := cellOf(shared.Coordinates{Latitude: box.MinLatitude, Longitude: box.MinLongitude})
+	upperRight := cellOf(shared.Coordinates{Latitude: box.MaxLatitude, Longitude: box.MaxLongitude})
+	candidates := make([]string, 0)
+	addCandidates := func(c locationCell) {
+		for _, id := range t.cells[c] {
+			if !slices.Contains(candidates, id) {
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	// for large areas it is cheaper to check all occupied cells than all cells of the area
+	if (upperRight.x-lowerLeft.x+1)*(upperRight.y-lowerLeft.y+1) > len(t.cells) {
+		for c := range t.cells {
+			if c.x >= lowerLeft.x && c.x <= upperRight.x && c.y >= lowerLeft.y && c.y <= upperRight.y {
+				addCandidates(c)
+			}
+		}
+	} else {
+		for x := lowerLeft.x; x <= upperRight.x; x++ {
+			for y := lowerLeft.y; y <= upperRight.y; y++ {
+				addCandidates(locationCell{x: x, y: y})
+			}
+		}
+	}
+	result := make([]TrackLocation, 0)
+	for _, id := range candidates {
+		densified := densifyOrRaw(t.tracks[id].Waypoints, locationIndexSpacing/10)
+		if slices.ContainsFunc(densified, box.Contains) {
+			result = append(result, t.location(id, 0))
+		}
+	}
+	sortTrackLocations(result)
+	return result
+}
+
+// FindNearest returns the track whose line is closest to the coordinates or nil if there is no track
+// within about 50 km
+func (t *TrackLocations) FindNearest(c shared.Coordinates) *TrackLocation {
+	t.RLock()
+	defer t.RUnlock()
+	center := cellOf(c)
+	// the smallest extent of a cell in meters, a ring of cells is at least that far away from the center cell
+	cellExtent := shared.Coordinates{Latitude: c.Latitude, Longitude: 0}.DistanceTo(
+		shared.Coordinates{Latitude: c.Latitude, Longitude: locationCellSize},
+	)
+	bestId := ""
+	bestDistance := math.MaxInt
+	visited := make(map[string]bool)
+	for ring := 0; ring <= maxNearestTrackRings; ring++ {
+		for x := center.x - ring; x <= center.x+ring; x++ {
+			for y := center.y - ring; y <= center.y+ring; y++ {
+				if max(abs(x-center.x), abs(y-center.y)) != ring {
+					continue
+				}
+				for _, id := range t.cells[locationCell{x: x, y: y}] {
+					if visited[id] {
+						continue
+					}
+					visited[id] = true
+					_, distance := t.tracks[id].Waypoints.Locate(c)
+					if distance < bestDistance {
+						bestDistance = distance
+						bestId = id
+					}
+				}
+			}
+		}
+		if bestId != "" && float64(bestDistance) <= float64(ring)*cellExtent {
+			break
+		}
+	}
+	if bestId == "" {
+		return nil
+	}
+	result := t.location(bestId, bestDistance)
+	return &result
+}
+
+// FindStartingNear returns all tracks starting within the radius (in meters) around the coordinates,
+// the closest first; the radius is limited to maxStartRadius
+func (t *TrackLocations) FindStartingNear(c shared.Coordinates, radius float64) []TrackLocation {
+	radius = math.Min(radius, maxStartRadius)
+	t.RLock()
+	defer t.RUnlock()
+	result := make([]TrackLocation, 0)
+	for _, id := range t.starts.Search(shared.Waypoints{c}.BoundingBox().Expand(radius)) {
+		distance := t.tracks[id].Waypoints[0].DistanceTo(c)
+		if distance <= radius {
+			result = append(result, t.location(id, int(distance)))
+		}
+	}
+	sortTrackLocations(result)
+	return result
+}
+
+func (t *TrackLocations) location(id string, distance int) TrackLocation {
+	track := t.tracks[id]
+	return TrackLocation{Id: id, Name: track.Name, Length: track.length, Distance: distance}
+}
+
+func sortTrackLocations(locations []TrackLocation) {
+	slices.SortFunc(
+		locations, func(a, b TrackLocation) int {
+			if a.Distance != b.Distance {
+				return a.Distance - b.Distance
+			}
+			return strings.Compare(a.Name, b.Name)
+		},
+	)
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func (t *TrackLocations) Waypoints(id string) shared.Waypoints {
+	t.RLock()
+	defer t.RUnlock()
+	return t.tracks[id].Waypoints
+}
@@ -0,0 +1,172 @@
+package projection
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrackRun is a journal entry as seen by the track statistics
+type TrackRun struct {
+	EntryId string
+	Date    time.Time
+	// the duration in seconds, zero if unknown
+	Duration int
+	Laps     int
+	Length   int
+	// true if the run covered the track exactly once, i.e. with one lap and without custom length
+	Regular bool
+}
+
+type trackRunEntry struct {
+	TrackId      string `json:"trackId"`
+	Date         string `json:"date"`
+	Duration     int    `json:"duration"`
+	Laps         int    `json:"laps"`
+	CustomLength *int   `json:"customLength,omitempty"`
+}
+
+type trackStatisticsData struct {
+	Lengths map[string]int           `json:"lengths"`
+	Runs    map[string]trackRunEntry `json:"runs"`
+}
+
+// TrackStatistics keeps the data of all journal entries needed to compute statistics per track, so that
+// the entries need not be read from disk.
+type TrackStatistics struct {
+	sync.RWMutex
+	data trackStatisticsData
+}
+
+func (t *TrackStatistics) ProjectionName() string {
+	return "trackStatistics"
+}
+
+func (t *TrackStatistics) Version() int {
+	return 1
+}
+
+func (t *TrackStatistics) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	t.Reset()
+	if message != nil {
+		_ = json.Unmarshal(message, &t.data)
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			t.Lock()
+			t.data.Lengths[event.Id] = event.Waypoints.Length()
+			t.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			t.Lock()
+			delete(t.data.Lengths, event.Id)
+			t.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+			t.Lock()
+			t.data.Runs[event.Id] = runEntryOf(*event.JournalEntry)
+			t.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+			t.Lock()
+			delete(t.data.Runs, event.Id)
+			t.Unlock()
+			writer()
+		},
+	)
+}
+
+func (t *TrackStatistics) Reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.data = trackStatisticsData{Lengths: make(map[string]int), Runs: make(map[string]trackRunEntry)}
+}
+
+func (t *TrackStatistics) Fresh() Projector {
+	result := &TrackStatistics{}
+	result.Reset()
+	return result
+}
+
+func (t *TrackStatistics) Swap(fresh Projector) {
+	t.Lock()
+	defer t.Unlock()
+	t.data = fresh.(*TrackStatistics).data
+}
+
+func (t *TrackStatistics) AddTrack(track shared.Track) {
+	t.Lock()
+	defer t.Unlock()
+	t.data.Lengths[track.Id] = track.Waypoints.Length()
+}
+
+func (t *TrackStatistics) AddJournalEntry(entry shared.JournalEntry) {
+	t.Lock()
+	defer t.Unlock()
+	t.data.Runs[entry.Id] = runEntryOf(entry)
+}
+
+func (t *TrackStatistics) GetData() any {
+	t.RLock()
+	defer t.RUnlock()
+	return t.data
+}
+
+func runEntryOf(entry shared.JournalEntry) trackRunEntry {
+	duration, _ := entry.Duration()
+	return trackRunEntry{
+		TrackId:      entry.TrackId,
+		Date:         entry.Date.Format(time.DateOnly),
+		Duration:     int(duration.Seconds()),
+		Laps:         entry.Laps,
+		CustomLength: entry.CustomLength,
+	}
+}
+
+// Runs returns all runs on the track, sorted by date
+func (t *TrackStatistics) Runs(trackId string) []TrackRun {
+	t.RLock()
+	defer t.RUnlock()
+	result := make([]TrackRun, 0)
+	for id, run := range t.data.Runs {
+		if run.TrackId != trackId {
+			continue
+		}
+		date, _ := time.Parse(time.DateOnly, run.Date)
+		length := t.data.Lengths[trackId] * run.Laps
+		if run.CustomLength != nil {
+			length = *run.CustomLength
+		}
+		result = append(
+			result, TrackRun{
+				EntryId:  id,
+				Date:     date,
+				Duration: run.Duration,
+				Laps:     run.Laps,
+				Length:   length,
+				Regular:  run.Laps == 1 && run.CustomLength == nil,
+			},
+		)
+	}
+	slices.SortFunc(
+		result, func(a, b TrackRun) int {
+			if compare := a.Date.Compare(b.Date); compare != 0 {
+				return compare
+			}
+			return strings.Compare(a.EntryId, b.EntryId)
+		},
+	)
+	return result
+}
@@ -28,26 +28,44 @@ func (t *TrackTree) ProjectionName() string {
 	return "trackTree"
 }
 
-func (t *TrackTree) Init(message json.RawMessage, writer func()) {
+func (t *TrackTree) Version() int {
+	return 1
+}
+
+func (t *TrackTree) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
 	if message == nil {
 		t.tree = &TrackTreeNode{Tracks: make([]TrackTreeEntry, 0), Nodes: make([]*TrackTreeNode, 0)}
 	} else {
 		_ = json.Unmarshal(message, &t.tree)
 	}
 	shared.Listen(
-		shared.TrackUpsertedEvent{}, func(k shared.TrackUpsertedEvent) {
+		bus, shared.TrackUpsertedEvent{}, func(k shared.TrackUpsertedEvent) {
 			t.handleUpsertEvent(k)
 			writer()
 		},
 	)
 	shared.Listen(
-		shared.TrackDeletedEvent{}, func(k shared.TrackDeletedEvent) {
+		bus, shared.TrackDeletedEvent{}, func(k shared.TrackDeletedEvent) {
 			t.handleDeleteEvent(t.tree, k.Id)
 			writer()
 		},
 	)
 }
 
+func (t *TrackTree) Reset() {
+	t.tree = &TrackTreeNode{Tracks: make([]TrackTreeEntry, 0), Nodes: make([]*TrackTreeNode, 0)}
+}
+
+func (t *TrackTree) Fresh() Projector {
+	result := &TrackTree{fileService: t.fileService}
+	result.Reset()
+	return result
+}
+
+func (t *TrackTree) Swap(fresh Projector) {
+	t.tree = fresh.(*TrackTree).tree
+}
+
 func (t *TrackTree) AddTrack(track shared.Track) {
 	hierarchy := track.Parents
 	node := t.tree
@@ -92,6 +110,12 @@ func (t *TrackTree) handleDeleteEvent(root *TrackTreeNode, id string) {
 	for i := range root.Nodes {
 		t.handleDeleteEvent(root.Nodes[i], id)
 	}
+	// folders only exist as long as they contain tracks
+	root.Nodes = slices.DeleteFunc(
+		root.Nodes, func(node *TrackTreeNode) bool {
+			return len(node.Tracks) == 0 && len(node.Nodes) == 0
+		},
+	)
 }
 
 func (t *TrackTree) handleUpsertEvent(track shared.TrackUpsertedEvent) {
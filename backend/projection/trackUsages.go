@@ -18,14 +18,18 @@ func (t *TrackUsages) ProjectionName() string {
 	return "trackUsages"
 }
 
-func (t *TrackUsages) Init(message json.RawMessage, writer func()) {
+func (t *TrackUsages) Version() int {
+	return 2
+}
+
+func (t *TrackUsages) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
 	if message != nil {
 		_ = json.Unmarshal(message, &t.content)
 	} else {
 		t.content = make(map[string][]string)
 	}
 	shared.Listen(
-		shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
+		bus, shared.JournalEntryDeletedEvent{}, func(event shared.JournalEntryDeletedEvent) {
 			t.Lock()
 			if _, ok := t.content[event.TrackId]; !ok {
 				t.Unlock()
@@ -41,7 +45,7 @@ func (t *TrackUsages) Init(message json.RawMessage, writer func()) {
 		},
 	)
 	shared.Listen(
-		shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
+		bus, shared.JournalEntryUpsertedEvent{}, func(event shared.JournalEntryUpsertedEvent) {
 			t.Lock()
 			old := event.OldTrackId
 			nevv := event.TrackId
@@ -60,17 +64,16 @@ func (t *TrackUsages) Init(message json.RawMessage, writer func()) {
 				)
 			}
 
-			if _, ok := t.content[nevv]; ok {
+			// entries with an embedded track do not use any track
+			if nevv != "" {
 				t.content[nevv] = append(t.content[nevv], event.Id)
-			} else {
-				t.content[nevv] = []string{event.Id}
 			}
 			t.Unlock()
 			writer()
 		},
 	)
 	shared.Listen(
-		shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
 			t.Lock()
 			delete(t.content, event.Id)
 			t.Unlock()
@@ -79,6 +82,24 @@ func (t *TrackUsages) Init(message json.RawMessage, writer func()) {
 	)
 }
 
+func (t *TrackUsages) Reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.content = make(map[string][]string)
+}
+
+func (t *TrackUsages) Fresh() Projector {
+	result := &TrackUsages{}
+	result.Reset()
+	return result
+}
+
+func (t *TrackUsages) Swap(fresh Projector) {
+	t.Lock()
+	defer t.Unlock()
+	t.content = fresh.(*TrackUsages).content
+}
+
 func (t *TrackUsages) AddTrack(track shared.Track) {
 	t.Lock()
 	defer t.Unlock()
@@ -88,6 +109,9 @@ func (t *TrackUsages) AddTrack(track shared.Track) {
 }
 
 func (t *TrackUsages) AddJournalEntry(entry shared.JournalEntry) {
+	if entry.TrackId == "" {
+		return
+	}
 	t.Lock()
 	defer t.Unlock()
 	if _, ok := t.content[entry.TrackId]; !ok {
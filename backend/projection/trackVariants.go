@@ -0,0 +1,167 @@
+package projection
+
+import (
+	"encoding/json"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type trackVariantEntry struct {
+	Name        string `json:"name"`
+	DerivesFrom string `json:"derivesFrom"`
+}
+
+// TrackVariants knows which track derives from which other track. A track together with all tracks
+// (transitively) deriving from it forms a track family; the track without origin is the family's root.
+type TrackVariants struct {
+	sync.RWMutex
+	tracks map[string]trackVariantEntry
+}
+
+func (t *TrackVariants) ProjectionName() string {
+	return "trackVariants"
+}
+
+func (t *TrackVariants) Version() int {
+	return 1
+}
+
+func (t *TrackVariants) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	t.Reset()
+	if message != nil {
+		_ = json.Unmarshal(message, &t.tracks)
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			t.Lock()
+			t.tracks[event.Id] = trackVariantEntry{Name: event.Name, DerivesFrom: event.DerivesFrom}
+			t.Unlock()
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			t.Lock()
+			delete(t.tracks, event.Id)
+			t.Unlock()
+			writer()
+		},
+	)
+}
+
+func (t *TrackVariants) Reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.tracks = make(map[string]trackVariantEntry)
+}
+
+func (t *TrackVariants) Fresh() Projector {
+	result := &TrackVariants{}
+	result.Reset()
+	return result
+}
+
+func (t *TrackVariants) Swap(fresh Projector) {
+	t.Lock()
+	defer t.Unlock()
+	t.tracks = fresh.(*TrackVariants).tracks
+}
+
+func (t *TrackVariants) AddTrack(track shared.Track) {
+	t.Lock()
+	defer t.Unlock()
+	t.tracks[track.Id] = trackVariantEntry{Name: track.Name, DerivesFrom: track.DerivesFrom}
+}
+
+func (t *TrackVariants) AddJournalEntry(entry shared.JournalEntry) {}
+
+func (t *TrackVariants) GetData() any {
+	t.RLock()
+	defer t.RUnlock()
+	return t.tracks
+}
+
+// Variants returns the ids of the tracks directly deriving from the given track, sorted by name
+func (t *TrackVariants) Variants(id string) []string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.variants(id)
+}
+
+func (t *TrackVariants) variants(id string) []string {
+	result := make([]string, 0)
+	for variantId, track := range t.tracks {
+		if track.DerivesFrom == id {
+			result = append(result, variantId)
+		}
+	}
+	slices.SortFunc(
+		result, func(a, b string) int {
+			return strings.Compare(t.tracks[a].Name, t.tracks[b].Name)
+		},
+	)
+	return result
+}
+
+// Root returns the root of the track's family. Origins that do not exist anymore are ignored.
+func (t *TrackVariants) Root(id string) string {
+	t.RLock()
+	defer t.RUnlock()
+	visited := map[string]bool{id: true}
+	current := id
+	for {
+		origin := t.tracks[current].DerivesFrom
+		if _, ok := t.tracks[origin]; !ok || visited[origin] {
+			return current
+		}
+		visited[origin] = true
+		current = origin
+	}
+}
+
+// Family returns the ids of the root and all its (transitive) variants, the root first
+func (t *TrackVariants) Family(root string) []string {
+	t.RLock()
+	defer t.RUnlock()
+	result := []string{root}
+	for index := 0; index < len(result); index++ {
+		for _, variant := range t.variants(result[index]) {
+			if !slices.Contains(result, variant) {
+				result = append(result, variant)
+			}
+		}
+	}
+	return result
+}
+
+// Origin returns the id of the track the given track derives from or an empty string
+func (t *TrackVariants) Origin(id string) string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.tracks[id].DerivesFrom
+}
+
+// Name returns the name of the track or an empty string if the track is unknown
+func (t *TrackVariants) Name(id string) string {
+	t.RLock()
+	defer t.RUnlock()
+	return t.tracks[id].Name
+}
+
+// IsDerivedFrom tells whether the track (transitively) derives from the given ancestor
+func (t *TrackVariants) IsDerivedFrom(id string, ancestor string) bool {
+	t.RLock()
+	defer t.RUnlock()
+	visited := map[string]bool{id: true}
+	current := t.tracks[id].DerivesFrom
+	for current != "" && !visited[current] {
+		if current == ancestor {
+			return true
+		}
+		visited[current] = true
+		current = t.tracks[current].DerivesFrom
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// at most this many differences are reported per projection
+const maxReportedDifferences = 20
+
+type Report struct {
+	Projection  string   `json:"projection"`
+	Consistent  bool     `json:"consistent"`
+	Differences []string `json:"differences"`
+}
+
+// Verify rebuilds every projection from the tracks and journal entries and reports how the projection
+// differed from the rebuilt state. The rebuilt data replaces the projection's data, thus inconsistent
+// projections are repaired at the same time.
+func (p *Projection) Verify() ([]Report, error) {
+	return p.verify(p.projectors)
+}
+
+// verify builds fresh instances of the projectors; the projectors do not receive events meanwhile, so that
+// none is lost when their data is replaced
+func (p *Projection) verify(projectors []Projector) ([]Report, error) {
+	for _, projector := range projectors {
+		target := p.buses[projector.ProjectionName()]
+		target.gate.Lock()
+		defer target.gate.Unlock()
+	}
+	fresh := make([]Projector, len(projectors))
+	for i, projector := range projectors {
+		fresh[i] = projector.Fresh()
+	}
+	err := p.feed(fresh)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Report, 0, len(projectors))
+	for i, projector := range projectors {
+		differences := make([]string, 0)
+		compareData(canonicalData(projector), canonicalData(fresh[i]), "", &differences)
+		if len(differences) > maxReportedDifferences {
+			differences = append(
+				differences[:maxReportedDifferences],
+				fmt.Sprintf("… and %d more", len(differences)-maxReportedDifferences),
+			)
+		}
+		projector.Swap(fresh[i])
+		p.writePayload(projector)
+		result = append(
+			result,
+			Report{Projection: projector.ProjectionName(), Consistent: len(differences) == 0, Differences: differences},
+		)
+	}
+	return result, nil
+}
+
+// repair rebuilds a single projection, e.g. after its data could not be written
+func (p *Projection) repair(projector Projector) {
+	name := projector.ProjectionName()
+	p.mutex.Lock()
+	if p.repairing[name] {
+		p.mutex.Unlock()
+		return
+	}
+	p.repairing[name] = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.repairing, name)
+		p.mutex.Unlock()
+	}()
+	log.Printf("repairing projection %s", name)
+	reports, err := p.verify([]Projector{projector})
+	if err != nil {
+		log.Printf("could not repair projection %s: %v", name, err)
+		return
+	}
+	for _, difference := range reports[0].Differences {
+		log.Printf("repaired projection %s: %s", name, difference)
+	}
+}
+
+// canonicalData returns the projector's data as generic JSON values with all arrays sorted, since the
+// order of list elements depends on the order of events and is not significant
+func canonicalData(projector Projector) any {
+	payload, _ := json.Marshal(projector.GetData())
+	var result any
+	_ = json.Unmarshal(payload, &result)
+	return canonicalize(result)
+}
+
+func canonicalize(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, element := range typed {
+			typed[key] = canonicalize(element)
+		}
+	case []any:
+		for i, element := range typed {
+			typed[i] = canonicalize(element)
+		}
+		slices.SortFunc(
+			typed, func(a, b any) int {
+				aPayload, _ := json.Marshal(a)
+				bPayload, _ := json.Marshal(b)
+				return strings.Compare(string(aPayload), string(bPayload))
+			},
+		)
+	}
+	return value
+}
+
+func compareData(actual any, expected any, path string, differences *[]string) {
+	actualMap, actualIsMap := actual.(map[string]any)
+	expectedMap, expectedIsMap := expected.(map[string]any)
+	if !actualIsMap || !expectedIsMap {
+		if !reflect.DeepEqual(actual, expected) {
+			*differences = append(*differences, fmt.Sprintf("%s: expected %s, but was %s", pathOrRoot(path), brief(expected), brief(actual)))
+		}
+		return
+	}
+	keys := make([]string, 0, len(actualMap)+len(expectedMap))
+	for key := range actualMap {
+		keys = append(keys, key)
+	}
+	for key := range expectedMap {
+		if _, ok := actualMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		actualValue, inActual := actualMap[key]
+		expectedValue, inExpected := expectedMap[key]
+		switch {
+		case !inActual:
+			*differences = append(*differences, fmt.Sprintf("%s/%s: missing", path, key))
+		case !inExpected:
+			*differences = append(*differences, fmt.Sprintf("%s/%s: obsolete", path, key))
+		default:
+			compareData(actualValue, expectedValue, path+"/"+key, differences)
+		}
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func brief(value any) string {
+	payload, _ := json.Marshal(value)
+	if len(payload) > 80 {
+		return string(payload[:77]) + "…"
+	}
+	return string(payload)
+}
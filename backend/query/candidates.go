@@ -0,0 +1,34 @@
+package query
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"log"
+)
+
+// Candidates resolves the tracks of the entries and computes their lengths
+func Candidates(entries []shared.JournalEntry, tracks *trackCache.Cache) []Candidate {
+	trackErrors := make(map[string]bool)
+	result := make([]Candidate, 0, len(entries))
+	for _, entry := range entries {
+		var track trackCache.Track
+		if !trackErrors[entry.TrackId] || entry.EmbeddedTrack != nil {
+			var err error
+			track, err = tracks.OfEntry(entry)
+			if err != nil {
+				log.Printf("could not read track of journal entry %s: %v", entry.Id, err)
+				trackErrors[entry.TrackId] = true
+			}
+		}
+		candidate := Candidate{Entry: entry, TrackName: track.Name, Length: track.Length * entry.Laps}
+		if trackErrors[entry.TrackId] && entry.EmbeddedTrack == nil {
+			candidate.TrackName = entry.TrackId
+			candidate.TrackError = true
+		}
+		if entry.CustomLength != nil {
+			candidate.Length = *entry.CustomLength
+		}
+		result = append(result, candidate)
+	}
+	return result
+}
@@ -0,0 +1,319 @@
+package query
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/markdown"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// tolerance used for comparing distances with ":" or "=", e.g. "distance:10km" matches 9.5 km to 10.5 km
+const distanceTolerance = 0.05
+
+type Candidate struct {
+	Entry      shared.JournalEntry
+	TrackName  string
+	TrackError bool
+	Length     int
+}
+
+type Query struct {
+	conditions []condition
+	Order      string
+}
+
+type condition struct {
+	negated bool
+	matches func(candidate Candidate) bool
+	// only set for date conditions, used to narrow the range of entries that must be read
+	from *time.Time
+	to   *time.Time
+}
+
+type term struct {
+	negated  bool
+	key      string
+	operator string
+	value    string
+}
+
+var operators = []string{">=", "<=", ":", "=", ">", "<"}
+
+// Parse parses queries like `track:"Forest loop" distance>10km date:2024 tag:long -rain`.
+// All terms must match; a leading "-" negates a term, terms without a key are searched in the comment
+// and the track name.
+func Parse(input string) (*Query, error) {
+	terms, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	result := &Query{conditions: make([]condition, 0, len(terms))}
+	for _, t := range terms {
+		if t.key == "sort" {
+			if t.negated || t.operator != ":" {
+				return nil, fmt.Errorf("sort must be given as sort:<field> or sort:-<field>")
+			}
+			result.Order = t.value
+			continue
+		}
+		c, err := t.toCondition()
+		if err != nil {
+			return nil, err
+		}
+		result.conditions = append(result.conditions, c)
+	}
+	if result.Order != "" {
+		if err := validateOrder(result.Order); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func tokenize(input string) ([]term, error) {
+	result := make([]term, 0)
+	runes := []rune(input)
+	position := 0
+	for position < len(runes) {
+		if unicode.IsSpace(runes[position]) {
+			position++
+			continue
+		}
+		current := term{}
+		if runes[position] == '-' {
+			current.negated = true
+			position++
+		}
+		start := position
+		for position < len(runes) && (unicode.IsLetter(runes[position]) || unicode.IsNumber(runes[position])) {
+			position++
+		}
+		word := string(runes[start:position])
+		rest := string(runes[position:])
+		for _, operator := range operators {
+			if word != "" && strings.HasPrefix(rest, operator) {
+				current.key = strings.ToLower(word)
+				current.operator = operator
+				position = position + len([]rune(operator))
+				break
+			}
+		}
+		if current.key == "" {
+			position = start
+		}
+		value, next, err := readValue(runes, position)
+		if err != nil {
+			return nil, err
+		}
+		position = next
+		if value == "" && current.key != "" {
+			return nil, fmt.Errorf("missing value for \"%s\"", current.key)
+		}
+		current.value = value
+		if value != "" {
+			result = append(result, current)
+		}
+	}
+	return result, nil
+}
+
+func readValue(runes []rune, position int) (string, int, error) {
+	if position < len(runes) && runes[position] == '"' {
+		end := position + 1
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		if end == len(runes) {
+			return "", 0, fmt.Errorf("missing closing quote at position %d", position)
+		}
+		return string(runes[position+1 : end]), end + 1, nil
+	}
+	end := position
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	return string(runes[position:end]), end, nil
+}
+
+func (t term) toCondition() (condition, error) {
+	result := condition{negated: t.negated}
+	var err error
+	switch t.key {
+	case "":
+		text := strings.ToLower(t.value)
+		result.matches = func(c Candidate) bool {
+			return strings.Contains(strings.ToLower(c.Entry.Comment), text) ||
+				strings.Contains(strings.ToLower(c.TrackName), text)
+		}
+	case "track":
+		err = t.requireOperator(":", "=")
+		text := strings.ToLower(t.value)
+		result.matches = func(c Candidate) bool {
+			return c.Entry.TrackId == t.value || strings.Contains(strings.ToLower(c.TrackName), text)
+		}
+	case "comment":
+		err = t.requireOperator(":", "=")
+		text := strings.ToLower(t.value)
+		result.matches = func(c Candidate) bool {
+			return strings.Contains(strings.ToLower(c.Entry.Comment), text)
+		}
+	case "tag":
+		err = t.requireOperator(":", "=")
+		tag := strings.ToLower(strings.TrimPrefix(t.value, "#"))
+		result.matches = func(c Candidate) bool {
+			return slices.Contains(markdown.Tags(c.Entry.Comment), tag)
+		}
+	case "distance":
+		var meters float64
+		meters, err = parseDistance(t.value)
+		result.matches = func(c Candidate) bool {
+			return compareFloat(float64(c.Length), t.operator, meters, meters*distanceTolerance)
+		}
+	case "time":
+		var duration time.Duration
+		duration, err = parseDuration(t.value)
+		result.matches = func(c Candidate) bool {
+			actual, err := c.Entry.Duration()
+			return err == nil && actual > 0 && compareFloat(actual.Seconds(), t.operator, duration.Seconds(), 0)
+		}
+	case "laps":
+		var laps int
+		laps, err = strconv.Atoi(t.value)
+		result.matches = func(c Candidate) bool {
+			return compareFloat(float64(c.Entry.Laps), t.operator, float64(laps), 0)
+		}
+	case "date":
+		result, err = t.toDateCondition()
+	default:
+		err = fmt.Errorf("unknown field \"%s\"", t.key)
+	}
+	if err != nil {
+		return condition{}, fmt.Errorf("invalid term \"%s%s%s\": %v", t.key, t.operator, t.value, err)
+	}
+	return result, nil
+}
+
+func (t term) requireOperator(allowed ...string) error {
+	if !slices.Contains(allowed, t.operator) {
+		return fmt.Errorf("operator %s is not supported for %s", t.operator, t.key)
+	}
+	return nil
+}
+
+// toDateCondition interprets the value as interval (a year, a month or a day); the operators refer to
+// that interval, e.g. "date>2024" means after 2024 and "date>=2024-05" means from May 2024 on
+func (t term) toDateCondition() (condition, error) {
+	var start time.Time
+	var err error
+	end := time.Time{}
+	switch len(t.value) {
+	case 4:
+		start, err = time.Parse("2006", t.value)
+		end = start.AddDate(1, 0, 0)
+	case 7:
+		start, err = time.Parse("2006-01", t.value)
+		end = start.AddDate(0, 1, 0)
+	default:
+		start, err = time.Parse(time.DateOnly, t.value)
+		end = start.AddDate(0, 0, 1)
+	}
+	if err != nil {
+		return condition{}, fmt.Errorf("dates must have the format YYYY, YYYY-MM, or YYYY-MM-DD")
+	}
+	result := condition{negated: t.negated}
+	switch t.operator {
+	case ":", "=":
+		result.from, result.to = &start, &end
+	case ">":
+		result.from = &end
+	case ">=":
+		result.from = &start
+	case "<":
+		result.to = &start
+	case "<=":
+		result.to = &end
+	}
+	from, to := result.from, result.to
+	result.matches = func(c Candidate) bool {
+		return (from == nil || !c.Entry.Date.Before(*from)) && (to == nil || c.Entry.Date.Before(*to))
+	}
+	return result, nil
+}
+
+func parseDistance(value string) (float64, error) {
+	factor := 1000.0
+	number := strings.ToLower(value)
+	if strings.HasSuffix(number, "km") {
+		number = strings.TrimSuffix(number, "km")
+	} else if strings.HasSuffix(number, "m") {
+		number = strings.TrimSuffix(number, "m")
+		factor = 1
+	}
+	result, err := strconv.ParseFloat(strings.Replace(number, ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("distances must be numbers with an optional unit (km or m)")
+	}
+	return result * factor, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if strings.Contains(value, ":") {
+		parts := strings.Split(value, ":")
+		if len(parts) == 2 {
+			parts = append([]string{"0"}, parts...)
+		}
+		return shared.JournalEntry{Time: strings.Join(parts, ":")}.Duration()
+	}
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("times must be given as hh:mm:ss, mm:ss, or like 1h30m")
+	}
+	return result, nil
+}
+
+func compareFloat(actual float64, operator string, expected float64, tolerance float64) bool {
+	switch operator {
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	}
+	return math.Abs(actual-expected) <= tolerance
+}
+
+func (q *Query) Matches(candidate Candidate) bool {
+	for _, c := range q.conditions {
+		if c.matches(candidate) == c.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// DateRange returns the smallest interval that contains all entries possibly matching the query.
+// The end of the interval is exclusive.
+func (q *Query) DateRange() (time.Time, time.Time) {
+	from := time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	for _, c := range q.conditions {
+		if c.negated {
+			continue
+		}
+		if c.from != nil && c.from.After(from) {
+			from = *c.from
+		}
+		if c.to != nil && c.to.Before(to) {
+			to = *c.to
+		}
+	}
+	return from, to
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	candidate := Candidate{
+		Entry: shared.JournalEntry{
+			Id: "2024-05-12-abc", TrackId: "forest", Date: time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC),
+			Comment: "Rainy run #long", Laps: 2, Time: "01:02:03",
+		},
+		TrackName: "Forest loop",
+		Length:    10200,
+	}
+	tests := []struct {
+		name    string
+		input   string
+		matches bool
+		order   string
+	}{
+		{name: "empty query", input: "", matches: true},
+		{name: "free text in comment", input: "rainy", matches: true},
+		{name: "free text in track name", input: "forest", matches: true},
+		{name: "negated free text", input: "-rainy", matches: false},
+		{name: "quoted track", input: `track:"forest loop"`, matches: true},
+		{name: "track id", input: "track=forest", matches: true},
+		{name: "other track", input: "track:meadow", matches: false},
+		{name: "comment", input: "comment:run", matches: true},
+		{name: "tag", input: "tag:#long", matches: true},
+		{name: "missing tag", input: "tag:short", matches: false},
+		{name: "distance within tolerance", input: "distance:10km", matches: true},
+		{name: "distance in meters", input: "distance>=10200m", matches: true},
+		{name: "distance with comma", input: "distance<10,1", matches: false},
+		{name: "time hh:mm:ss", input: "time<01:10:00", matches: true},
+		{name: "time mm:ss", input: "time>59:00", matches: true},
+		{name: "time duration", input: "time>=2h", matches: false},
+		{name: "laps", input: "laps=2", matches: true},
+		{name: "year", input: "date:2024", matches: true},
+		{name: "after month", input: "date>2024-05", matches: false},
+		{name: "from month", input: "date>=2024-05", matches: true},
+		{name: "before day", input: "date<2024-05-12", matches: false},
+		{name: "until day", input: "date<=2024-05-12", matches: true},
+		{name: "all terms must match", input: "rainy laps:3", matches: false},
+		{name: "sort ascending", input: "rainy sort:distance", matches: true, order: "distance"},
+		{name: "sort descending", input: "sort:-date", matches: true, order: "-date"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := Parse(tt.input)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.Matches(candidate) != tt.matches {
+					t.Errorf("Matches() = %v, want %v", !tt.matches, tt.matches)
+				}
+				if got.Order != tt.order {
+					t.Errorf("Order = %s, want %s", got.Order, tt.order)
+				}
+			},
+		)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "missing closing quote", input: `track:"forest`},
+		{name: "missing value", input: "track:"},
+		{name: "unknown field", input: "color:red"},
+		{name: "unsupported operator", input: "track>forest"},
+		{name: "invalid distance", input: "distance>far"},
+		{name: "invalid time", input: "time<soon"},
+		{name: "invalid laps", input: "laps=two"},
+		{name: "invalid date", input: "date:12.05.2024"},
+		{name: "unknown sort field", input: "sort:color"},
+		{name: "negated sort", input: "-sort:date"},
+		{name: "sort with comparison", input: "sort>date"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := Parse(tt.input)
+				if err == nil {
+					t.Errorf("expected an error for %s", tt.input)
+				}
+			},
+		)
+	}
+}
+
+func TestQuery_DateRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		from  string
+		to    string
+	}{
+		{name: "unrestricted", input: "rainy", from: "0001-01-01", to: "9999-12-31"},
+		{name: "year", input: "date:2024", from: "2024-01-01", to: "2025-01-01"},
+		{name: "combined bounds", input: "date>=2024-03 date<2024-05-10", from: "2024-03-01", to: "2024-05-10"},
+		{name: "negated conditions are ignored", input: "-date:2024", from: "0001-01-01", to: "9999-12-31"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				query, err := Parse(tt.input)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				from, to := query.DateRange()
+				if from.Format(time.DateOnly) != tt.from || to.Format(time.DateOnly) != tt.to {
+					t.Errorf(
+						"DateRange() = %s, %s, want %s, %s", from.Format(time.DateOnly), to.Format(time.DateOnly),
+						tt.from, tt.to,
+					)
+				}
+			},
+		)
+	}
+}
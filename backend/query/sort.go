@@ -0,0 +1,74 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var comparators = map[string]func(a, b Candidate) int{
+	"date": func(a, b Candidate) int {
+		return a.Entry.Date.Compare(b.Entry.Date)
+	},
+	"distance": func(a, b Candidate) int {
+		return cmp.Compare(a.Length, b.Length)
+	},
+	"time": func(a, b Candidate) int {
+		durationA, _ := a.Entry.Duration()
+		durationB, _ := b.Entry.Duration()
+		return cmp.Compare(durationA, durationB)
+	},
+	"track": func(a, b Candidate) int {
+		return strings.Compare(strings.ToLower(a.TrackName), strings.ToLower(b.TrackName))
+	},
+	"laps": func(a, b Candidate) int {
+		return cmp.Compare(a.Entry.Laps, b.Entry.Laps)
+	},
+}
+
+func validateOrder(order string) error {
+	if _, ok := comparators[strings.TrimPrefix(order, "-")]; !ok {
+		return fmt.Errorf("cannot sort by \"%s\"", strings.TrimPrefix(order, "-"))
+	}
+	return nil
+}
+
+// Sort sorts the candidates by the given field (date, distance, time, track, or laps). A leading "-"
+// sorts in descending order. Candidates with equal values are sorted by date.
+func Sort(candidates []Candidate, order string) error {
+	if order == "" {
+		order = "date"
+	}
+	err := validateOrder(order)
+	if err != nil {
+		return err
+	}
+	descending := strings.HasPrefix(order, "-")
+	comparator := comparators[strings.TrimPrefix(order, "-")]
+	slices.SortStableFunc(
+		candidates, func(a, b Candidate) int {
+			result := comparator(a, b)
+			if result == 0 {
+				result = comparators["date"](a, b)
+			}
+			if descending {
+				return -result
+			}
+			return result
+		},
+	)
+	return nil
+}
+
+// Page returns the candidates of the given zero-based page. A page size of zero returns all candidates.
+func Page(candidates []Candidate, page int, pageSize int) []Candidate {
+	if pageSize <= 0 {
+		return candidates
+	}
+	// both are clamped such that the product cannot overflow
+	pageSize = min(pageSize, max(len(candidates), 1))
+	page = min(max(page, 0), len(candidates))
+	start := min(page*pageSize, len(candidates))
+	return candidates[start:min(start+pageSize, len(candidates))]
+}
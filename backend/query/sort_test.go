@@ -0,0 +1,51 @@
+package query
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	candidates := make([]Candidate, 5)
+	for i := range candidates {
+		candidates[i].Length = i
+	}
+	tests := []struct {
+		name     string
+		page     int
+		pageSize int
+		want     []int
+	}{
+		{name: "first page", page: 0, pageSize: 2, want: []int{0, 1}},
+		{name: "last partial page", page: 2, pageSize: 2, want: []int{4}},
+		{name: "beyond the last page", page: 3, pageSize: 2, want: []int{}},
+		{name: "negative page", page: -1, pageSize: 2, want: []int{0, 1}},
+		{name: "zero page size returns all", page: 1, pageSize: 0, want: []int{0, 1, 2, 3, 4}},
+		{name: "negative page size returns all", page: 1, pageSize: -3, want: []int{0, 1, 2, 3, 4}},
+		{name: "page size larger than candidates", page: 0, pageSize: 10, want: []int{0, 1, 2, 3, 4}},
+		{name: "huge page", page: math.MaxInt, pageSize: 2, want: []int{}},
+		{name: "huge page size", page: 1, pageSize: math.MaxInt, want: []int{}},
+		{name: "huge page and page size", page: math.MaxInt, pageSize: math.MaxInt, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got := make([]int, 0)
+				for _, candidate := range Page(candidates, tt.page, tt.pageSize) {
+					got = append(got, candidate.Length)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("Page() = %v, want %v", got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestPage_Empty(t *testing.T) {
+	got := Page(nil, math.MaxInt, math.MaxInt)
+	if len(got) != 0 {
+		t.Errorf("Page() = %v, want no candidates", got)
+	}
+}
@@ -0,0 +1,66 @@
+package routing
+
+import (
+	"container/heap"
+	"math"
+)
+
+type queueItem struct {
+	vertex   int32
+	priority float64
+}
+
+type priorityQueue []queueItem
+
+func (p priorityQueue) Len() int { return len(p) }
+
+func (p priorityQueue) Less(i, j int) bool { return p[i].priority < p[j].priority }
+
+func (p priorityQueue) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *priorityQueue) Push(x any) { *p = append(*p, x.(queueItem)) }
+
+func (p *priorityQueue) Pop() any {
+	old := *p
+	item := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return item
+}
+
+// shortestPath computes the shortest path between two vertices with A*, using the air-line distance as
+// heuristic. It returns nil if the target is not reachable.
+func (g *graph) shortestPath(from int32, to int32) []int32 {
+	distances := map[int32]float64{from: 0}
+	predecessors := make(map[int32]int32)
+	closed := make(map[int32]bool)
+	queue := &priorityQueue{{vertex: from, priority: g.coordinates[from].DistanceTo(g.coordinates[to])}}
+	for queue.Len() > 0 {
+		current := heap.Pop(queue).(queueItem).vertex
+		if current == to {
+			path := []int32{to}
+			for path[len(path)-1] != from {
+				path = append(path, predecessors[path[len(path)-1]])
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		if closed[current] {
+			continue
+		}
+		closed[current] = true
+		for _, e := range g.edges[current] {
+			distance := distances[current] + float64(e.length)
+			known, ok := distances[e.target]
+			if ok && known <= distance {
+				continue
+			}
+			distances[e.target] = distance
+			predecessors[e.target] = current
+			heuristic := g.coordinates[e.target].DistanceTo(g.coordinates[to])
+			heap.Push(queue, queueItem{vertex: e.target, priority: distance + math.Max(0, heuristic)})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package routing
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+)
+
+// the grid cells used to snap coordinates to the graph are 0.005 degrees wide and high
+const snapCellSize = 0.005
+
+// highway values a runner may use; motorways and trunk roads are left out
+var runnableHighways = map[string]bool{
+	"footway": true, "path": true, "track": true, "pedestrian": true, "steps": true, "bridleway": true,
+	"cycleway": true, "living_street": true, "residential": true, "service": true, "unclassified": true,
+	"road": true, "tertiary": true, "tertiary_link": true, "secondary": true, "secondary_link": true,
+	"primary": true, "primary_link": true,
+}
+
+func isRunnable(tags map[string]string) bool {
+	if !runnableHighways[tags["highway"]] {
+		return false
+	}
+	if tags["area"] == "yes" {
+		return false
+	}
+	switch tags["foot"] {
+	case "yes", "designated", "permissive":
+		return true
+	case "no", "private":
+		return false
+	}
+	return tags["access"] != "no" && tags["access"] != "private"
+}
+
+type edge struct {
+	target int32
+	length float32
+}
+
+type snapCell struct {
+	x int
+	y int
+}
+
+// graph contains the runnable ways of the extract; the vertices are the nodes of those ways
+type graph struct {
+	coordinates []shared.Coordinates
+	edges       [][]edge
+	cells       map[snapCell][]int32
+}
+
+func newGraph() *graph {
+	return &graph{cells: make(map[snapCell][]int32)}
+}
+
+func (g *graph) addVertex(c shared.Coordinates) int32 {
+	index := int32(len(g.coordinates))
+	g.coordinates = append(g.coordinates, c)
+	g.edges = append(g.edges, nil)
+	cell := snapCellOf(c)
+	g.cells[cell] = append(g.cells[cell], index)
+	return index
+}
+
+func (g *graph) addEdge(from int32, to int32) {
+	length := float32(g.coordinates[from].DistanceTo(g.coordinates[to]))
+	g.edges[from] = append(g.edges[from], edge{target: to, length: length})
+	g.edges[to] = append(g.edges[to], edge{target: from, length: length})
+}
+
+func snapCellOf(c shared.Coordinates) snapCell {
+	return snapCell{x: int(math.Floor(c.Longitude / snapCellSize)), y: int(math.Floor(c.Latitude / snapCellSize))}
+}
+
+// nearest returns the vertex closest to the coordinates within the given radius (in meters) or -1
+func (g *graph) nearest(c shared.Coordinates, radius float64) int32 {
+	center := snapCellOf(c)
+	rings := int(math.Ceil(radius/(snapCellSize*111_000*math.Cos(c.Latitude*math.Pi/180)))) + 1
+	best := int32(-1)
+	bestDistance := radius
+	for x := center.x - rings; x <= center.x+rings; x++ {
+		for y := center.y - rings; y <= center.y+rings; y++ {
+			for _, vertex := range g.cells[snapCell{x: x, y: y}] {
+				if len(g.edges[vertex]) == 0 {
+					continue
+				}
+				distance := g.coordinates[vertex].DistanceTo(c)
+				if distance <= bestDistance {
+					best = vertex
+					bestDistance = distance
+				}
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,326 @@
+package routing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// the maximal sizes defined by the OSM PBF specification
+const (
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+type pbfWay struct {
+	tags map[string]string
+	refs []int64
+}
+
+// pbfReader reads an OSM PBF file (https://wiki.openstreetmap.org/wiki/PBF_Format). Only the parts needed
+// for routing are decoded: ways with their tags and references, and the coordinates of nodes.
+type pbfReader struct {
+	path string
+}
+
+type primitiveBlock struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+	groups      [][]byte
+}
+
+// readWays calls the consumer for every way in the file
+func (p *pbfReader) readWays(consumer func(way pbfWay)) error {
+	return p.readBlocks(
+		func(block primitiveBlock) error {
+			for _, group := range block.groups {
+				fields := newMessage(group)
+				for {
+					ok, err := fields.next()
+					if err != nil || !ok {
+						if err != nil {
+							return err
+						}
+						break
+					}
+					if fields.field != 3 {
+						continue
+					}
+					way, err := decodeWay(fields.bytes, block.strings)
+					if err != nil {
+						return err
+					}
+					consumer(way)
+				}
+			}
+			return nil
+		},
+	)
+}
+
+// readNodes calls the consumer for every node in the file, given in degrees
+func (p *pbfReader) readNodes(consumer func(id int64, latitude float64, longitude float64)) error {
+	return p.readBlocks(
+		func(block primitiveBlock) error {
+			toDegrees := func(offset int64, value int64) float64 {
+				return 1e-9 * float64(offset+block.granularity*value)
+			}
+			for _, group := range block.groups {
+				fields := newMessage(group)
+				for {
+					ok, err := fields.next()
+					if err != nil {
+						return err
+					}
+					if !ok {
+						break
+					}
+					switch fields.field {
+					case 1:
+						id, lat, lon, err := decodeNode(fields.bytes)
+						if err != nil {
+							return err
+						}
+						consumer(id, toDegrees(block.latOffset, lat), toDegrees(block.lonOffset, lon))
+					case 2:
+						ids, lats, lons, err := decodeDenseNodes(fields.bytes)
+						if err != nil {
+							return err
+						}
+						for i := range ids {
+							consumer(ids[i], toDegrees(block.latOffset, lats[i]), toDegrees(block.lonOffset, lons[i]))
+						}
+					}
+				}
+			}
+			return nil
+		},
+	)
+}
+
+func (p *pbfReader) readBlocks(consumer func(block primitiveBlock) error) error {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", p.path, err)
+	}
+	defer file.Close()
+	sizeBuffer := make([]byte, 4)
+	for {
+		_, err = io.ReadFull(file, sizeBuffer)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read blob header size: %v", err)
+		}
+		headerSize := binary.BigEndian.Uint32(sizeBuffer)
+		if headerSize > maxBlobHeaderSize {
+			return fmt.Errorf("blob header too large: %d bytes", headerSize)
+		}
+		header := make([]byte, headerSize)
+		_, err = io.ReadFull(file, header)
+		if err != nil {
+			return fmt.Errorf("could not read blob header: %v", err)
+		}
+		blobType, blobSize, err := decodeBlobHeader(header)
+		if err != nil {
+			return err
+		}
+		if blobSize > maxBlobSize {
+			return fmt.Errorf("blob too large: %d bytes", blobSize)
+		}
+		blob := make([]byte, blobSize)
+		_, err = io.ReadFull(file, blob)
+		if err != nil {
+			return fmt.Errorf("could not read blob: %v", err)
+		}
+		if blobType != "OSMData" {
+			continue
+		}
+		data, err := decodeBlob(blob)
+		if err != nil {
+			return err
+		}
+		block, err := decodePrimitiveBlock(data)
+		if err != nil {
+			return err
+		}
+		err = consumer(block)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func decodeBlobHeader(data []byte) (string, uint64, error) {
+	fields := newMessage(data)
+	blobType := ""
+	size := uint64(0)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return "", 0, fmt.Errorf("could not decode blob header: %v", err)
+		}
+		if !ok {
+			return blobType, size, nil
+		}
+		switch fields.field {
+		case 1:
+			blobType = string(fields.bytes)
+		case 3:
+			size = fields.varint
+		}
+	}
+}
+
+func decodeBlob(data []byte) ([]byte, error) {
+	fields := newMessage(data)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode blob: %v", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("blob contains no supported data (only raw and zlib are supported)")
+		}
+		switch fields.field {
+		case 1:
+			return fields.bytes, nil
+		case 3:
+			reader, err := zlib.NewReader(bytes.NewReader(fields.bytes))
+			if err != nil {
+				return nil, fmt.Errorf("could not decompress blob: %v", err)
+			}
+			result, err := io.ReadAll(io.LimitReader(reader, maxBlobSize))
+			_ = reader.Close()
+			if err != nil {
+				return nil, fmt.Errorf("could not decompress blob: %v", err)
+			}
+			return result, nil
+		}
+	}
+}
+
+func decodePrimitiveBlock(data []byte) (primitiveBlock, error) {
+	result := primitiveBlock{granularity: 100, groups: make([][]byte, 0)}
+	fields := newMessage(data)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return primitiveBlock{}, fmt.Errorf("could not decode primitive block: %v", err)
+		}
+		if !ok {
+			return result, nil
+		}
+		switch fields.field {
+		case 1:
+			table := newMessage(fields.bytes)
+			for {
+				ok, err := table.next()
+				if err != nil {
+					return primitiveBlock{}, fmt.Errorf("could not decode string table: %v", err)
+				}
+				if !ok {
+					break
+				}
+				result.strings = append(result.strings, table.bytes)
+			}
+		case 2:
+			result.groups = append(result.groups, fields.bytes)
+		case 17:
+			result.granularity = int64(fields.varint)
+		case 19:
+			result.latOffset = int64(fields.varint)
+		case 20:
+			result.lonOffset = int64(fields.varint)
+		}
+	}
+}
+
+func decodeWay(data []byte, strings [][]byte) (pbfWay, error) {
+	result := pbfWay{tags: make(map[string]string)}
+	var keys, values []uint64
+	fields := newMessage(data)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return pbfWay{}, fmt.Errorf("could not decode way: %v", err)
+		}
+		if !ok {
+			break
+		}
+		switch fields.field {
+		case 2:
+			keys, err = fields.packedVarints()
+		case 3:
+			values, err = fields.packedVarints()
+		case 8:
+			result.refs, err = fields.packedDeltas()
+		}
+		if err != nil {
+			return pbfWay{}, fmt.Errorf("could not decode way: %v", err)
+		}
+	}
+	for i := range keys {
+		if i < len(values) && keys[i] < uint64(len(strings)) && values[i] < uint64(len(strings)) {
+			result.tags[string(strings[keys[i]])] = string(strings[values[i]])
+		}
+	}
+	return result, nil
+}
+
+func decodeNode(data []byte) (int64, int64, int64, error) {
+	var id, lat, lon int64
+	fields := newMessage(data)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("could not decode node: %v", err)
+		}
+		if !ok {
+			return id, lat, lon, nil
+		}
+		switch fields.field {
+		case 1:
+			id = fields.sint64()
+		case 8:
+			lat = fields.sint64()
+		case 9:
+			lon = fields.sint64()
+		}
+	}
+}
+
+func decodeDenseNodes(data []byte) ([]int64, []int64, []int64, error) {
+	var ids, lats, lons []int64
+	fields := newMessage(data)
+	for {
+		ok, err := fields.next()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not decode dense nodes: %v", err)
+		}
+		if !ok {
+			break
+		}
+		switch fields.field {
+		case 1:
+			ids, err = fields.packedDeltas()
+		case 8:
+			lats, err = fields.packedDeltas()
+		case 9:
+			lons, err = fields.packedDeltas()
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not decode dense nodes: %v", err)
+		}
+	}
+	if len(ids) != len(lats) || len(ids) != len(lons) {
+		return nil, nil, nil, fmt.Errorf("dense nodes have inconsistent lengths")
+	}
+	return ids, lats, lons, nil
+}
@@ -0,0 +1,169 @@
+package routing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fixture nodes: 1 to 3 form a footway heading north, 3 and 4 are connected by a motorway, 5 is unused
+var fixtureNodes = []struct {
+	id        int64
+	latitude  float64
+	longitude float64
+}{
+	{id: 1, latitude: 49.7900, longitude: 9.9300},
+	{id: 2, latitude: 49.7910, longitude: 9.9300},
+	{id: 3, latitude: 49.7920, longitude: 9.9300},
+	{id: 4, latitude: 49.7920, longitude: 9.9400},
+	{id: 5, latitude: 49.8000, longitude: 9.9500},
+}
+
+type protobuf []byte
+
+func (p protobuf) varint(field int, value uint64) protobuf {
+	p = binary.AppendUvarint(p, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(p, value)
+}
+
+func (p protobuf) bytes(field int, value []byte) protobuf {
+	p = binary.AppendUvarint(p, uint64(field<<3|wireBytes))
+	p = binary.AppendUvarint(p, uint64(len(value)))
+	return append(p, value...)
+}
+
+func packed(values ...uint64) []byte {
+	result := make([]byte, 0)
+	for _, value := range values {
+		result = binary.AppendUvarint(result, value)
+	}
+	return result
+}
+
+func toZigzag(value int64) uint64 {
+	return uint64(value<<1) ^ uint64(value>>63)
+}
+
+func packedDeltas(values ...int64) []byte {
+	result := make([]uint64, len(values))
+	previous := int64(0)
+	for i, value := range values {
+		result[i] = toZigzag(value - previous)
+		previous = value
+	}
+	return packed(result...)
+}
+
+// writeFixture writes an extract with a header blob and one zlib compressed data block containing
+// dense nodes, a plain node, and two ways
+func writeFixture(t *testing.T, directory string) string {
+	t.Helper()
+	// lat_offset is used to shift all latitudes, the granularity is the default of 100 nanodegrees
+	const latOffset = 1_000_000_000
+	toUnits := func(degrees float64, offset int64) int64 {
+		return int64(math.Round((degrees*1e9 - float64(offset)) / 100))
+	}
+	var ids, lats, lons []int64
+	for _, node := range fixtureNodes[:4] {
+		ids = append(ids, node.id)
+		lats = append(lats, toUnits(node.latitude, latOffset))
+		lons = append(lons, toUnits(node.longitude, 0))
+	}
+	dense := protobuf{}.bytes(1, packedDeltas(ids...)).
+		bytes(8, packedDeltas(lats...)).
+		bytes(9, packedDeltas(lons...))
+	last := fixtureNodes[4]
+	node := protobuf{}.varint(1, toZigzag(last.id)).
+		varint(8, toZigzag(toUnits(last.latitude, latOffset))).
+		varint(9, toZigzag(toUnits(last.longitude, 0)))
+	strings := protobuf{}.bytes(1, []byte("")).bytes(1, []byte("highway")).bytes(1, []byte("footway")).
+		bytes(1, []byte("motorway"))
+	footway := protobuf{}.varint(1, 10).bytes(2, packed(1)).bytes(3, packed(2)).bytes(8, packedDeltas(1, 2, 3))
+	motorway := protobuf{}.varint(1, 11).bytes(2, packed(1)).bytes(3, packed(3)).bytes(8, packedDeltas(3, 4))
+	block := protobuf{}.bytes(1, strings).
+		bytes(2, protobuf{}.bytes(2, dense).bytes(1, node)).
+		bytes(2, protobuf{}.bytes(3, footway).bytes(3, motorway)).
+		varint(19, latOffset)
+
+	compressed := bytes.Buffer{}
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write(block)
+	_ = writer.Close()
+
+	file := make([]byte, 0)
+	appendBlob := func(blobType string, blob protobuf) {
+		header := protobuf{}.bytes(1, []byte(blobType)).varint(3, uint64(len(blob)))
+		file = binary.BigEndian.AppendUint32(file, uint32(len(header)))
+		file = append(append(file, header...), blob...)
+	}
+	appendBlob("OSMHeader", protobuf{}.bytes(1, []byte("ignored")))
+	appendBlob("OSMData", protobuf{}.varint(2, uint64(len(block))).bytes(3, compressed.Bytes()))
+	path := filepath.Join(directory, "fixture.osm.pbf")
+	err := os.WriteFile(path, file, 0644)
+	if err != nil {
+		t.Fatalf("could not write fixture: %v", err)
+	}
+	return path
+}
+
+func TestPbfReader_readNodes(t *testing.T) {
+	reader := pbfReader{path: writeFixture(t, t.TempDir())}
+	got := make(map[int64]shared.Coordinates)
+	err := reader.readNodes(
+		func(id int64, latitude float64, longitude float64) {
+			got[id] = shared.Coordinates{Latitude: latitude, Longitude: longitude}
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(fixtureNodes) {
+		t.Fatalf("got %d nodes, want %d", len(got), len(fixtureNodes))
+	}
+	for _, node := range fixtureNodes {
+		coordinates := got[node.id]
+		if math.Abs(coordinates.Latitude-node.latitude) > 1e-7 ||
+			math.Abs(coordinates.Longitude-node.longitude) > 1e-7 {
+			t.Errorf("node %d = %v, want (%f, %f)", node.id, coordinates, node.latitude, node.longitude)
+		}
+	}
+}
+
+func TestPbfReader_readWays(t *testing.T) {
+	reader := pbfReader{path: writeFixture(t, t.TempDir())}
+	got := make([]pbfWay, 0)
+	err := reader.readWays(
+		func(way pbfWay) {
+			got = append(got, way)
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d ways, want 2", len(got))
+	}
+	if got[0].tags["highway"] != "footway" || !slices.Equal(got[0].refs, []int64{1, 2, 3}) {
+		t.Errorf("first way = %v, want footway with refs [1 2 3]", got[0])
+	}
+	if got[1].tags["highway"] != "motorway" || !slices.Equal(got[1].refs, []int64{3, 4}) {
+		t.Errorf("second way = %v, want motorway with refs [3 4]", got[1])
+	}
+}
+
+func TestPbfReader_truncated(t *testing.T) {
+	path := writeFixture(t, t.TempDir())
+	content, _ := os.ReadFile(path)
+	_ = os.WriteFile(path, content[:len(content)-5], 0644)
+	reader := pbfReader{path: path}
+	err := reader.readWays(func(way pbfWay) {})
+	if err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+}
@@ -0,0 +1,121 @@
+package routing
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	wireVarint = 0
+	wire64Bit  = 1
+	wireBytes  = 2
+	wire32Bit  = 5
+)
+
+// message is a minimal decoder for the protocol buffer wire format, which is all that is needed to
+// read the few message types of the OSM PBF format
+type message struct {
+	data     []byte
+	position int
+	field    int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+func newMessage(data []byte) *message {
+	return &message{data: data}
+}
+
+// next reads the next field; it returns false at the end of the message
+func (m *message) next() (bool, error) {
+	if m.position >= len(m.data) {
+		return false, nil
+	}
+	key, err := m.readVarint()
+	if err != nil {
+		return false, err
+	}
+	m.field = int(key >> 3)
+	m.wireType = int(key & 7)
+	switch m.wireType {
+	case wireVarint:
+		m.varint, err = m.readVarint()
+	case wire64Bit:
+		if m.position+8 > len(m.data) {
+			return false, fmt.Errorf("unexpected end of message")
+		}
+		m.varint = binary.LittleEndian.Uint64(m.data[m.position:])
+		m.position = m.position + 8
+	case wireBytes:
+		var length uint64
+		length, err = m.readVarint()
+		// compared as uint64 because huge lengths would become negative as int
+		if err == nil && length > uint64(len(m.data)-m.position) {
+			err = fmt.Errorf("unexpected end of message")
+		}
+		if err == nil {
+			m.bytes = m.data[m.position : m.position+int(length)]
+			m.position = m.position + int(length)
+		}
+	case wire32Bit:
+		if m.position+4 > len(m.data) {
+			return false, fmt.Errorf("unexpected end of message")
+		}
+		m.varint = uint64(binary.LittleEndian.Uint32(m.data[m.position:]))
+		m.position = m.position + 4
+	default:
+		err = fmt.Errorf("unsupported wire type %d", m.wireType)
+	}
+	return err == nil, err
+}
+
+func (m *message) readVarint() (uint64, error) {
+	value, length := binary.Uvarint(m.data[m.position:])
+	if length <= 0 {
+		return 0, fmt.Errorf("invalid varint at position %d", m.position)
+	}
+	m.position = m.position + length
+	return value, nil
+}
+
+func (m *message) sint64() int64 {
+	return zigzag(m.varint)
+}
+
+func zigzag(value uint64) int64 {
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+// packedVarints decodes the bytes of the current field as packed repeated varints
+func (m *message) packedVarints() ([]uint64, error) {
+	if m.wireType == wireVarint {
+		return []uint64{m.varint}, nil
+	}
+	result := make([]uint64, 0, len(m.bytes))
+	position := 0
+	for position < len(m.bytes) {
+		value, length := binary.Uvarint(m.bytes[position:])
+		if length <= 0 {
+			return nil, fmt.Errorf("invalid packed varint")
+		}
+		result = append(result, value)
+		position = position + length
+	}
+	return result, nil
+}
+
+// packedDeltas decodes packed, delta-coded sint64 values
+func (m *message) packedDeltas() ([]int64, error) {
+	values, err := m.packedVarints()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]int64, len(values))
+	current := int64(0)
+	for i, value := range values {
+		current = current + zigzag(value)
+		result[i] = current
+	}
+	return result, nil
+}
@@ -0,0 +1,182 @@
+package routing
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// clicked points farther away than this (in meters) from any runnable way cannot be snapped
+const maxSnapDistance = 500
+
+// Router plans routes along footpaths and roads of the OpenStreetMap extracts (*.osm.pbf) placed in the
+// config directory. The extracts are read when the first route is requested; no network access is needed.
+type Router struct {
+	sync.Mutex
+	directory string
+	graph     *graph
+	loadedAt  map[string]int64
+}
+
+func New(directory string) *Router {
+	return &Router{directory: directory}
+}
+
+func (r *Router) extracts() ([]string, error) {
+	entries, err := os.ReadDir(r.directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %v", r.directory, err)
+	}
+	result := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".osm.pbf") {
+			result = append(result, filepath.Join(r.directory, entry.Name()))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Available tells whether there is at least one extract to route on
+func (r *Router) Available() bool {
+	extracts, err := r.extracts()
+	return err == nil && len(extracts) > 0
+}
+
+// Route connects the given points along runnable ways. The result starts and ends exactly at the
+// first and last point.
+func (r *Router) Route(points shared.Waypoints) (shared.Waypoints, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("at least two points are needed to plan a route")
+	}
+	r.Lock()
+	defer r.Unlock()
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	vertices := make([]int32, len(points))
+	for index, point := range points {
+		vertices[index] = r.graph.nearest(point, maxSnapDistance)
+		if vertices[index] < 0 {
+			return nil, fmt.Errorf(
+				"point %d (%f, %f) is not within %d m of a path or road", index+1, point.Latitude, point.Longitude,
+				maxSnapDistance,
+			)
+		}
+	}
+	result := shared.Waypoints{points[0]}
+	for index := 0; index < len(vertices)-1; index++ {
+		path := r.graph.shortestPath(vertices[index], vertices[index+1])
+		if path == nil {
+			return nil, fmt.Errorf("there is no route between point %d and point %d", index+1, index+2)
+		}
+		for _, vertex := range path {
+			coordinates := r.graph.coordinates[vertex]
+			if result[len(result)-1] != coordinates {
+				result = append(result, coordinates)
+			}
+		}
+	}
+	if result[len(result)-1] != points[len(points)-1] {
+		result = append(result, points[len(points)-1])
+	}
+	return result, nil
+}
+
+// load builds the graph unless it is already built from the current extracts
+func (r *Router) load() error {
+	extracts, err := r.extracts()
+	if err != nil {
+		return err
+	}
+	if len(extracts) == 0 {
+		return fmt.Errorf("no OpenStreetMap extract (*.osm.pbf) found in %s", r.directory)
+	}
+	modified := make(map[string]int64)
+	for _, extract := range extracts {
+		info, err := os.Stat(extract)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", extract, err)
+		}
+		modified[extract] = info.ModTime().UnixNano()
+	}
+	if r.graph != nil && mapsEqual(modified, r.loadedAt) {
+		return nil
+	}
+	g := newGraph()
+	vertices := make(map[int64]int32)
+	for _, extract := range extracts {
+		log.Printf("reading OpenStreetMap extract %s", extract)
+		err = readExtract(extract, g, vertices)
+		if err != nil {
+			return fmt.Errorf("could not read OpenStreetMap extract %s: %v", extract, err)
+		}
+	}
+	r.graph = g
+	r.loadedAt = modified
+	return nil
+}
+
+func mapsEqual(a map[string]int64, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// readExtract reads the file twice: first the runnable ways are collected, then the coordinates of
+// their nodes. Thus, only the nodes needed for routing are kept in memory.
+// The vertices map the OSM node ids to the vertices of the graph, it is shared by all extracts so that
+// overlapping extracts are connected.
+func readExtract(path string, g *graph, vertices map[int64]int32) error {
+	reader := pbfReader{path: path}
+	ways := make([]pbfWay, 0)
+	err := reader.readWays(
+		func(way pbfWay) {
+			if len(way.refs) < 2 || !isRunnable(way.tags) {
+				return
+			}
+			ways = append(ways, pbfWay{refs: way.refs})
+			for _, ref := range way.refs {
+				if _, ok := vertices[ref]; !ok {
+					vertices[ref] = -1
+				}
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+	err = reader.readNodes(
+		func(id int64, latitude float64, longitude float64) {
+			if vertex, ok := vertices[id]; ok && vertex < 0 {
+				vertices[id] = g.addVertex(shared.Coordinates{Latitude: latitude, Longitude: longitude})
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+	// one-way streets are ignored since runners may use them in both directions
+	for _, way := range ways {
+		for index := 0; index < len(way.refs)-1; index++ {
+			from, to := vertices[way.refs[index]], vertices[way.refs[index+1]]
+			if from < 0 || to < 0 || from == to {
+				continue
+			}
+			g.addEdge(from, to)
+		}
+	}
+	return nil
+}
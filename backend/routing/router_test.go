@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+	"testing"
+)
+
+func TestRouter_Route(t *testing.T) {
+	directory := t.TempDir()
+	writeFixture(t, directory)
+	router := New(directory)
+	if !router.Available() {
+		t.Fatalf("the fixture should be available")
+	}
+	start := shared.Coordinates{Latitude: 49.7899, Longitude: 9.9301}
+	end := shared.Coordinates{Latitude: 49.7921, Longitude: 9.9299}
+	got, err := router.Route(shared.Waypoints{start, end})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []shared.Coordinates{start}
+	for _, node := range fixtureNodes[:3] {
+		want = append(want, shared.Coordinates{Latitude: node.latitude, Longitude: node.longitude})
+	}
+	want = append(want, end)
+	if len(got) != len(want) {
+		t.Fatalf("Route() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i].Latitude-want[i].Latitude) > 1e-7 ||
+			math.Abs(got[i].Longitude-want[i].Longitude) > 1e-7 {
+			t.Errorf("waypoint %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRouter_Route_motorwayIsNotRunnable(t *testing.T) {
+	directory := t.TempDir()
+	writeFixture(t, directory)
+	router := New(directory)
+	// node 4 is only reachable by the motorway, thus the point cannot be snapped to any way
+	_, err := router.Route(
+		shared.Waypoints{{Latitude: 49.7900, Longitude: 9.9300}, {Latitude: 49.7920, Longitude: 9.9400}},
+	)
+	if err == nil {
+		t.Errorf("expected an error for a point only reachable by a motorway")
+	}
+}
+
+func TestRouter_Route_withoutExtract(t *testing.T) {
+	router := New(t.TempDir())
+	if router.Available() {
+		t.Errorf("a directory without extracts should not be available")
+	}
+	_, err := router.Route(
+		shared.Waypoints{{Latitude: 49.79, Longitude: 9.93}, {Latitude: 49.80, Longitude: 9.94}},
+	)
+	if err == nil {
+		t.Errorf("expected an error without extracts")
+	}
+}
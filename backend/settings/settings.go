@@ -2,6 +2,7 @@ package settings
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fafeitsch/private-running-journal/backend/shared"
 	"os"
@@ -17,11 +18,21 @@ type MapSettings struct {
 }
 
 type AppSettings struct {
-	MapSettings  MapSettings `json:"mapSettings"`
-	HttpPort     int         `json:"httpPort"`
-	Language     string      `json:"language"`
-	GitSettings  GitSettings `json:"gitSettings"`
-	HeadlessMode bool        `json:"headlessMode"`
+	MapSettings     MapSettings     `json:"mapSettings"`
+	HttpPort        int             `json:"httpPort"`
+	Language        string          `json:"language"`
+	GitSettings     GitSettings     `json:"gitSettings"`
+	WeatherSettings WeatherSettings `json:"weatherSettings"`
+	HeadlessMode    bool            `json:"headlessMode"`
+	// SqliteIndex answers list and dashboard queries from an SQLite index; changes take effect after a restart
+	SqliteIndex bool `json:"sqliteIndex"`
+	// TrashRetentionDays is the number of days deleted items are kept in the trash, 0 keeps them forever
+	TrashRetentionDays int `json:"trashRetentionDays"`
+}
+
+type WeatherSettings struct {
+	ProviderUrl        string `json:"providerUrl"`
+	FetchAutomatically bool   `json:"fetchAutomatically"`
 }
 
 type GitSettings struct {
@@ -31,12 +42,13 @@ type GitSettings struct {
 }
 
 type Settings struct {
+	bus          *shared.EventBus
 	settingsFile string
 	appSettings  AppSettings
 }
 
-func New(baseDirectory string) (*Settings, error) {
-	result := Settings{settingsFile: filepath.Join(baseDirectory, "settings.json")}
+func New(bus *shared.EventBus, baseDirectory string) (*Settings, error) {
+	result := Settings{bus: bus, settingsFile: filepath.Join(baseDirectory, "settings.json")}
 	err := result.initSettings()
 	return &result, err
 }
@@ -50,9 +62,14 @@ func (s *Settings) initSettings() error {
 			ZoomLevel:   6,
 			Center:      [2]float64{51.330, 10.453},
 		},
-		HttpPort:     47836,
-		Language:     "en",
-		HeadlessMode: false,
+		WeatherSettings: WeatherSettings{
+			ProviderUrl:        "https://archive-api.open-meteo.com/v1/archive?latitude={lat}&longitude={lon}&start_date={date}&end_date={date}&daily=temperature_2m_mean,wind_speed_10m_max,precipitation_sum,weather_code",
+			FetchAutomatically: false,
+		},
+		HttpPort:           47836,
+		Language:           "en",
+		HeadlessMode:       false,
+		TrashRetentionDays: 30,
 	}
 	_, err := os.Stat(s.settingsFile)
 	if nil == err {
@@ -72,20 +89,38 @@ func (s *Settings) SaveSettings(settings AppSettings) error {
 	if err != nil {
 		return fmt.Errorf("could not save settings: %v", err)
 	}
+	errs := make([]error, 0)
 	if settings.MapSettings.TileServer != s.appSettings.MapSettings.TileServer {
-		shared.SendEvent(shared.TileServerChangedEvent{NewValue: settings.MapSettings.TileServer})
+		errs = append(errs, s.bus.Send(shared.TileServerChangedEvent{NewValue: settings.MapSettings.TileServer}))
 	}
 	if settings.MapSettings.CacheTiles != s.appSettings.MapSettings.CacheTiles {
-		shared.SendEvent(shared.TileServerCacheEnabledEvent{NewValue: settings.MapSettings.CacheTiles})
+		errs = append(errs, s.bus.Send(shared.TileServerCacheEnabledEvent{NewValue: settings.MapSettings.CacheTiles}))
 	}
 	if settings.GitSettings.Enabled != s.appSettings.GitSettings.Enabled {
-		shared.SendEvent(shared.GitEnablementChangedEvent{NewValue: settings.GitSettings.Enabled})
+		errs = append(errs, s.bus.Send(shared.GitEnablementChangedEvent{NewValue: settings.GitSettings.Enabled}))
 	}
 	if settings.GitSettings.PushAfterCommit != s.appSettings.GitSettings.PushAfterCommit {
-		shared.SendEvent(shared.GitPushChangedEvent{NewValue: settings.GitSettings.PushAfterCommit})
+		errs = append(errs, s.bus.Send(shared.GitPushChangedEvent{NewValue: settings.GitSettings.PushAfterCommit}))
+	}
+	if settings.WeatherSettings.ProviderUrl != s.appSettings.WeatherSettings.ProviderUrl {
+		errs = append(
+			errs, s.bus.Send(shared.WeatherProviderChangedEvent{NewValue: settings.WeatherSettings.ProviderUrl}),
+		)
+	}
+	if settings.WeatherSettings.FetchAutomatically != s.appSettings.WeatherSettings.FetchAutomatically {
+		errs = append(
+			errs, s.bus.Send(shared.WeatherAutoFetchChangedEvent{NewValue: settings.WeatherSettings.FetchAutomatically}),
+		)
 	}
-	shared.SendEvent(shared.SettingsChangedEvent{})
+	if settings.TrashRetentionDays != s.appSettings.TrashRetentionDays {
+		errs = append(errs, s.bus.Send(shared.TrashRetentionChangedEvent{NewValue: settings.TrashRetentionDays}))
+	}
+	errs = append(errs, s.bus.Send(shared.SettingsChangedEvent{}))
 	s.appSettings = settings
+	err = errors.Join(errs...)
+	if err != nil {
+		return fmt.Errorf("could not apply settings: %v", err)
+	}
 	return nil
 }
 
@@ -98,3 +133,5 @@ func (s *Settings) MapSettings() MapSettings {
 }
 
 func (s *Settings) GitSettings() GitSettings { return s.appSettings.GitSettings }
+
+func (s *Settings) WeatherSettings() WeatherSettings { return s.appSettings.WeatherSettings }
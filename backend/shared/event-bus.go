@@ -2,13 +2,15 @@ package shared
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"reflect"
+	"slices"
+	"sync"
 	"time"
 )
 
-type Handler func(data ...any)
-
 type TrackUpsertedEvent struct {
 	*SaveTrack
 }
@@ -27,6 +29,10 @@ type JournalEntryDeletedEvent struct {
 	*JournalEntry
 }
 
+type JournalAttachmentsChangedEvent struct {
+	EntryId string
+}
+
 type GitEnablementChangedEvent struct {
 	NewValue bool
 }
@@ -45,39 +51,199 @@ type TileServerCacheEnabledEvent struct {
 	NewValue bool
 }
 
+type WeatherProviderChangedEvent struct {
+	NewValue string
+}
+
+type WeatherAutoFetchChangedEvent struct {
+	NewValue bool
+}
+
+type TrashRetentionChangedEvent struct {
+	NewValue int
+}
+
 type MigrationEvent struct {
 	OldVersion int
 	NewVersion int
 }
 
-var handlers = make(map[string][]Handler)
-
 var Context context.Context
 
-func SendEvent(data any) {
-	namedType := reflect.TypeOf(data)
-	if _, ok := handlers[namedType.String()]; !ok {
-		return
+// EventBus delivers events to the handlers subscribed to the type of the event. Handlers are called on the
+// sender's goroutine, unless they are subscribed with Async(): then they receive the events in the order
+// they were sent on a goroutine of their own.
+type EventBus struct {
+	mutex        sync.RWMutex
+	subscribers  map[reflect.Type][]*Subscription
+	onAsyncError func(err error)
+}
+
+type Subscription struct {
+	bus       *EventBus
+	eventType reflect.Type
+	handler   func(event any) error
+	async     bool
+	mutex     sync.Mutex
+	changed   *sync.Cond
+	queue     []any
+	busy      bool
+	closed    bool
+}
+
+type SubscribeOption func(subscription *Subscription)
+
+// Async lets the handler receive the events asynchronously; its errors are reported to the bus' error handler
+func Async() SubscribeOption {
+	return func(subscription *Subscription) {
+		subscription.async = true
 	}
-	for _, handler := range handlers[namedType.String()] {
-		handler(data)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[reflect.Type][]*Subscription),
+		onAsyncError: func(err error) {
+			log.Printf("asynchronous event handler failed: %v", err)
+		},
 	}
 }
 
-func Listen[K any](event K, handler func(k K)) {
-	namedType := reflect.TypeOf(event)
-	_, ok := handlers[namedType.String()]
-	if !ok {
-		handlers[namedType.String()] = make([]Handler, 0)
+// OnAsyncError replaces the callback that is invoked with the errors of asynchronous handlers
+func (b *EventBus) OnAsyncError(callback func(err error)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.onAsyncError = callback
+}
+
+// Subscribe registers a handler for all events of the same type as event
+func Subscribe[K any](bus *EventBus, event K, handler func(k K) error, options ...SubscribeOption) *Subscription {
+	subscription := &Subscription{
+		bus:       bus,
+		eventType: reflect.TypeOf(event),
+		handler: func(event any) error {
+			return handler(event.(K))
+		},
 	}
-	handlers[namedType.String()] = append(
-		handlers[namedType.String()], func(data ...any) {
-			payload := data[0]
-			payloadType := reflect.TypeOf(payload)
-			if payloadType != namedType {
-				log.Fatalf("handler for %s received parameter of type %s", namedType, payloadType.String())
-			}
-			handler(payload.(K))
+	subscription.changed = sync.NewCond(&subscription.mutex)
+	for _, option := range options {
+		option(subscription)
+	}
+	if subscription.async {
+		go subscription.work()
+	}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subscribers[subscription.eventType] = append(bus.subscribers[subscription.eventType], subscription)
+	return subscription
+}
+
+// Listen registers a handler that cannot fail for all events of the same type as event
+func Listen[K any](bus *EventBus, event K, handler func(k K), options ...SubscribeOption) *Subscription {
+	return Subscribe(
+		bus, event, func(k K) error {
+			handler(k)
+			return nil
+		}, options...,
+	)
+}
+
+// Send delivers the event to all subscribers and returns the errors of the synchronous handlers
+func (b *EventBus) Send(event any) error {
+	b.mutex.RLock()
+	subscribers := slices.Clone(b.subscribers[reflect.TypeOf(event)])
+	b.mutex.RUnlock()
+	errs := make([]error, 0)
+	for _, subscriber := range subscribers {
+		if subscriber.async {
+			subscriber.enqueue(event)
+			continue
+		}
+		err := subscriber.call(event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Wait blocks until the asynchronous handlers processed all events sent so far
+func (b *EventBus) Wait() {
+	b.mutex.RLock()
+	subscribers := make([]*Subscription, 0)
+	for _, list := range b.subscribers {
+		subscribers = append(subscribers, list...)
+	}
+	b.mutex.RUnlock()
+	for _, subscriber := range subscribers {
+		subscriber.mutex.Lock()
+		for len(subscriber.queue) > 0 || subscriber.busy {
+			subscriber.changed.Wait()
+		}
+		subscriber.mutex.Unlock()
+	}
+}
+
+// Unsubscribe removes the handler from the bus; an asynchronous handler still receives the events already sent
+func (s *Subscription) Unsubscribe() {
+	s.bus.mutex.Lock()
+	s.bus.subscribers[s.eventType] = slices.DeleteFunc(
+		s.bus.subscribers[s.eventType], func(subscription *Subscription) bool {
+			return subscription == s
 		},
 	)
+	s.bus.mutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	s.changed.Broadcast()
+}
+
+func (s *Subscription) call(event any) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler for %s panicked: %v", s.eventType, recovered)
+		}
+	}()
+	return s.handler(event)
+}
+
+func (s *Subscription) enqueue(event any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, event)
+	s.changed.Broadcast()
+}
+
+func (s *Subscription) work() {
+	for {
+		s.mutex.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.changed.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		s.busy = true
+		s.mutex.Unlock()
+
+		err := s.call(event)
+		if err != nil {
+			s.bus.mutex.RLock()
+			report := s.bus.onAsyncError
+			s.bus.mutex.RUnlock()
+			report(err)
+		}
+
+		s.mutex.Lock()
+		s.busy = false
+		s.changed.Broadcast()
+		s.mutex.Unlock()
+	}
 }
@@ -0,0 +1,143 @@
+package shared
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testEvent struct {
+	number int
+}
+
+type otherTestEvent struct{}
+
+func TestEventBus_Send_joinsErrors(t *testing.T) {
+	bus := NewEventBus()
+	first := errors.New("first failed")
+	second := errors.New("second failed")
+	called := 0
+	Subscribe(bus, testEvent{}, func(k testEvent) error {
+		called++
+		return first
+	})
+	Listen(bus, testEvent{}, func(k testEvent) {
+		called++
+	})
+	Subscribe(bus, testEvent{}, func(k testEvent) error {
+		called++
+		return second
+	})
+	otherCalled := false
+	Listen(bus, otherTestEvent{}, func(k otherTestEvent) {
+		otherCalled = true
+	})
+	err := bus.Send(testEvent{number: 1})
+	if called != 3 {
+		t.Errorf("%d handlers called, want 3", called)
+	}
+	if !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Errorf("Send() = %v, want both errors", err)
+	}
+	if otherCalled {
+		t.Errorf("the handler of another event type must not be called")
+	}
+}
+
+func TestEventBus_Send_async(t *testing.T) {
+	bus := NewEventBus()
+	mutex := sync.Mutex{}
+	asyncErrors := make([]error, 0)
+	bus.OnAsyncError(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		asyncErrors = append(asyncErrors, err)
+	})
+	received := make([]int, 0)
+	Subscribe(bus, testEvent{}, func(k testEvent) error {
+		received = append(received, k.number)
+		if k.number == 50 {
+			return errors.New("async failed")
+		}
+		return nil
+	}, Async())
+	for i := range 100 {
+		err := bus.Send(testEvent{number: i})
+		if err != nil {
+			t.Fatalf("Send() = %v, errors of asynchronous handlers must not be returned", err)
+		}
+	}
+	bus.Wait()
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+	}
+	if !slices.Equal(received, want) {
+		t.Errorf("received %v, want the events in the order they were sent", received)
+	}
+	if len(asyncErrors) != 1 || asyncErrors[0].Error() != "async failed" {
+		t.Errorf("reported errors %v, want exactly the error of the asynchronous handler", asyncErrors)
+	}
+}
+
+func TestEventBus_Send_recoversPanics(t *testing.T) {
+	bus := NewEventBus()
+	mutex := sync.Mutex{}
+	asyncErrors := make([]error, 0)
+	bus.OnAsyncError(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		asyncErrors = append(asyncErrors, err)
+	})
+	Listen(bus, testEvent{}, func(k testEvent) {
+		panic("sync handler broken")
+	})
+	syncCalled := false
+	Listen(bus, testEvent{}, func(k testEvent) {
+		syncCalled = true
+	})
+	asyncReceived := make([]int, 0)
+	Listen(bus, testEvent{}, func(k testEvent) {
+		asyncReceived = append(asyncReceived, k.number)
+		if k.number == 1 {
+			panic("async handler broken")
+		}
+	}, Async())
+	err := bus.Send(testEvent{number: 1})
+	if err == nil || !strings.Contains(err.Error(), "sync handler broken") {
+		t.Errorf("Send() = %v, want the panic as error", err)
+	}
+	if !syncCalled {
+		t.Errorf("the handlers after the panicking handler must be called")
+	}
+	_ = bus.Send(testEvent{number: 2})
+	bus.Wait()
+	if !slices.Equal(asyncReceived, []int{1, 2}) {
+		t.Errorf("asynchronous handler received %v, want [1 2]", asyncReceived)
+	}
+	if len(asyncErrors) != 1 || !strings.Contains(asyncErrors[0].Error(), "async handler broken") {
+		t.Errorf("reported errors %v, want the panic of the asynchronous handler", asyncErrors)
+	}
+}
+
+func TestSubscription_Unsubscribe(t *testing.T) {
+	bus := NewEventBus()
+	syncCalls, asyncCalls := 0, 0
+	syncSubscription := Listen(bus, testEvent{}, func(k testEvent) {
+		syncCalls++
+	})
+	asyncSubscription := Listen(bus, testEvent{}, func(k testEvent) {
+		asyncCalls++
+	}, Async())
+	_ = bus.Send(testEvent{})
+	bus.Wait()
+	syncSubscription.Unsubscribe()
+	asyncSubscription.Unsubscribe()
+	_ = bus.Send(testEvent{})
+	bus.Wait()
+	if syncCalls != 1 || asyncCalls != 1 {
+		t.Errorf("handlers called %d and %d times, want once each", syncCalls, asyncCalls)
+	}
+}
@@ -0,0 +1,266 @@
+package shared
+
+import (
+	"fmt"
+	"math"
+)
+
+func (c Coordinates) DistanceTo(other Coordinates) float64 {
+	return distanceBetweenTwoPoints(c.Latitude, c.Longitude, other.Latitude, other.Longitude) * 1000
+}
+
+// Locate projects the coordinates onto the nearest segment of the waypoints and returns the distance
+// from the start of the waypoints to the projected point as well as the distance between the coordinates
+// and the projected point, both in meters
+func (w Waypoints) Locate(c Coordinates) (int, int) {
+	if len(w) == 0 {
+		return 0, 0
+	}
+	if len(w) == 1 {
+		return 0, int(w[0].DistanceTo(c))
+	}
+	bestAlong := 0.0
+	bestOffset := math.MaxFloat64
+	accumulated := 0.0
+	for index := 0; index < len(w)-1; index++ {
+		start := w[index]
+		ratio, offset := projectOntoSegment(start, w[index+1], c)
+		segmentLength := start.DistanceTo(w[index+1])
+		if offset < bestOffset {
+			bestOffset = offset
+			bestAlong = accumulated + ratio*segmentLength
+		}
+		accumulated = accumulated + segmentLength
+	}
+	return int(bestAlong), int(bestOffset)
+}
+
+// projectOntoSegment uses an equirectangular approximation around the start of the segment, which is
+// precise enough for the short segments of running tracks
+func projectOntoSegment(start Coordinates, end Coordinates, point Coordinates) (float64, float64) {
+	endX, endY := toLocalPlane(start, end)
+	pointX, pointY := toLocalPlane(start, point)
+	squaredLength := endX*endX + endY*endY
+	ratio := 0.0
+	if squaredLength > 0 {
+		ratio = math.Max(0, math.Min(1, (pointX*endX+pointY*endY)/squaredLength))
+	}
+	return ratio, math.Hypot(pointX-ratio*endX, pointY-ratio*endY)
+}
+
+func toLocalPlane(origin Coordinates, c Coordinates) (float64, float64) {
+	earthRadius := 6371800.0 // Earth radius in meters
+	x := degreesToRadians(c.Longitude-origin.Longitude) * math.Cos(degreesToRadians(origin.Latitude)) * earthRadius
+	y := degreesToRadians(c.Latitude-origin.Latitude) * earthRadius
+	return x, y
+}
+
+func (w Waypoints) Reverse() Waypoints {
+	result := make(Waypoints, len(w))
+	for index := range w {
+		result[len(w)-1-index] = w[index]
+	}
+	return result
+}
+
+// Split splits the waypoints at the given index. The waypoint at the index is contained in both parts.
+func (w Waypoints) Split(index int) (Waypoints, Waypoints, error) {
+	if index <= 0 || index >= len(w)-1 {
+		return nil, nil, fmt.Errorf("cannot split %d waypoints at index %d", len(w), index)
+	}
+	first := make(Waypoints, index+1)
+	copy(first, w[:index+1])
+	second := make(Waypoints, len(w)-index)
+	copy(second, w[index:])
+	return first, second, nil
+}
+
+// Concat appends the other waypoints; if the other waypoints start where these end, the duplicate is dropped
+func (w Waypoints) Concat(other Waypoints) Waypoints {
+	result := make(Waypoints, 0, len(w)+len(other))
+	result = append(result, w...)
+	if len(w) > 0 && len(other) > 0 && w[len(w)-1] == other[0] {
+		other = other[1:]
+	}
+	return append(result, other...)
+}
+
+// Simplify removes waypoints using the Douglas-Peucker algorithm, such that the simplified line deviates
+// at most the tolerance (in meters) from the original line
+func (w Waypoints) Simplify(tolerance float64) Waypoints {
+	if len(w) < 3 {
+		return append(Waypoints{}, w...)
+	}
+	keep := make([]bool, len(w))
+	keep[0] = true
+	keep[len(w)-1] = true
+	stack := [][2]int{{0, len(w) - 1}}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		maxDistance := 0.0
+		maxIndex := -1
+		for index := current[0] + 1; index < current[1]; index++ {
+			_, distance := projectOntoSegment(w[current[0]], w[current[1]], w[index])
+			if distance > maxDistance {
+				maxDistance = distance
+				maxIndex = index
+			}
+		}
+		if maxIndex != -1 && maxDistance > tolerance {
+			keep[maxIndex] = true
+			stack = append(stack, [2]int{current[0], maxIndex}, [2]int{maxIndex, current[1]})
+		}
+	}
+	result := make(Waypoints, 0)
+	for index, waypoint := range w {
+		if keep[index] {
+			result = append(result, waypoint)
+		}
+	}
+	return result
+}
+
+// the smallest spacing (in meters) and the largest number of resulting waypoints Densify accepts
+const (
+	minDensifySpacing     = 1
+	maxDensifiedWaypoints = 1000000
+)
+
+// Densify inserts interpolated waypoints such that no two consecutive waypoints are farther apart than
+// the given spacing (in meters)
+func (w Waypoints) Densify(spacing float64) (Waypoints, error) {
+	if !(spacing >= minDensifySpacing) {
+		return nil, fmt.Errorf("spacing must be at least %d m, but is %f", minDensifySpacing, spacing)
+	}
+	steps := make([]int, len(w))
+	total := len(w)
+	for index := 1; index < len(w); index++ {
+		distance := math.Ceil(w[index-1].DistanceTo(w[index]) / spacing)
+		if distance > maxDensifiedWaypoints {
+			return nil, fmt.Errorf("densifying would result in more than %d waypoints", maxDensifiedWaypoints)
+		}
+		steps[index] = int(distance)
+		total = total + max(steps[index]-1, 0)
+		if total > maxDensifiedWaypoints {
+			return nil, fmt.Errorf("densifying would result in more than %d waypoints", maxDensifiedWaypoints)
+		}
+	}
+	result := make(Waypoints, 0, total)
+	for index := range w {
+		if index > 0 {
+			previous := w[index-1]
+			for step := 1; step < steps[index]; step++ {
+				ratio := float64(step) / float64(steps[index])
+				result = append(
+					result, Coordinates{
+						Latitude:  previous.Latitude + ratio*(w[index].Latitude-previous.Latitude),
+						Longitude: previous.Longitude + ratio*(w[index].Longitude-previous.Longitude),
+					},
+				)
+			}
+		}
+		result = append(result, w[index])
+	}
+	return result, nil
+}
+
+func (w Waypoints) CloseLoop() Waypoints {
+	result := append(Waypoints{}, w...)
+	if len(w) > 1 && w[0] != w[len(w)-1] {
+		result = append(result, w[0])
+	}
+	return result
+}
+
+type BoundingBox struct {
+	MinLatitude  float64 `json:"minLatitude"`
+	MinLongitude float64 `json:"minLongitude"`
+	MaxLatitude  float64 `json:"maxLatitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+}
+
+func (w Waypoints) BoundingBox() BoundingBox {
+	if len(w) == 0 {
+		return BoundingBox{}
+	}
+	result := BoundingBox{
+		MinLatitude: w[0].Latitude, MinLongitude: w[0].Longitude, MaxLatitude: w[0].Latitude,
+		MaxLongitude: w[0].Longitude,
+	}
+	for _, waypoint := range w[1:] {
+		result.MinLatitude = math.Min(result.MinLatitude, waypoint.Latitude)
+		result.MinLongitude = math.Min(result.MinLongitude, waypoint.Longitude)
+		result.MaxLatitude = math.Max(result.MaxLatitude, waypoint.Latitude)
+		result.MaxLongitude = math.Max(result.MaxLongitude, waypoint.Longitude)
+	}
+	return result
+}
+
+func (b BoundingBox) Intersects(other BoundingBox) bool {
+	return b.MinLatitude <= other.MaxLatitude && other.MinLatitude <= b.MaxLatitude &&
+		b.MinLongitude <= other.MaxLongitude && other.MinLongitude <= b.MaxLongitude
+}
+
+func (b BoundingBox) Contains(c Coordinates) bool {
+	return c.Latitude >= b.MinLatitude && c.Latitude <= b.MaxLatitude &&
+		c.Longitude >= b.MinLongitude && c.Longitude <= b.MaxLongitude
+}
+
+// Expand grows the bounding box by the given distance (in meters) in every direction, but not beyond
+// the valid coordinates
+func (b BoundingBox) Expand(meters float64) BoundingBox {
+	meters = math.Max(meters, 0)
+	earthRadius := 6371800.0 // Earth radius in meters
+	latitudeDelta := meters / earthRadius * 180 / math.Pi
+	cosine := math.Max(math.Cos(degreesToRadians(math.Max(math.Abs(b.MinLatitude), math.Abs(b.MaxLatitude)))), 0.01)
+	longitudeDelta := latitudeDelta / cosine
+	return BoundingBox{
+		MinLatitude:  math.Max(b.MinLatitude-latitudeDelta, -90),
+		MinLongitude: math.Max(b.MinLongitude-longitudeDelta, -180),
+		MaxLatitude:  math.Min(b.MaxLatitude+latitudeDelta, 90),
+		MaxLongitude: math.Min(b.MaxLongitude+longitudeDelta, 180),
+	}
+}
+
+// FrechetDistance computes the discrete Fréchet distance (in meters) between the waypoints. The distance
+// respects the direction of the waypoints. Sparse waypoints should be densified beforehand.
+func FrechetDistance(a Waypoints, b Waypoints) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return math.Inf(1)
+	}
+	previous := make([]float64, len(b))
+	current := make([]float64, len(b))
+	for i := range a {
+		for j := range b {
+			distance := a[i].DistanceTo(b[j])
+			switch {
+			case i == 0 && j == 0:
+				current[j] = distance
+			case i == 0:
+				current[j] = math.Max(current[j-1], distance)
+			case j == 0:
+				current[j] = math.Max(previous[j], distance)
+			default:
+				current[j] = math.Max(math.Min(math.Min(previous[j], previous[j-1]), current[j-1]), distance)
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)-1]
+}
+
+// Coverage returns the share of the waypoints that lie within the tolerance (in meters) of the other waypoints
+func (w Waypoints) Coverage(other Waypoints, tolerance float64) float64 {
+	if len(w) == 0 {
+		return 0
+	}
+	covered := 0
+	for _, waypoint := range w {
+		_, offset := other.Locate(waypoint)
+		if float64(offset) <= tolerance {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(w))
+}
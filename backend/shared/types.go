@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"fmt"
 	"math"
 	"time"
 )
@@ -86,19 +87,21 @@ type DistanceMarker struct {
 }
 
 type Track struct {
-	Waypoints Waypoints
-	Id        string   `json:"id"`
-	Name      string   `json:"name"`
-	Parents   []string `json:"parents"`
-	Comment   string   `json:"string"`
+	Waypoints   Waypoints
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Parents     []string `json:"parents"`
+	Comment     string   `json:"string"`
+	DerivesFrom string   `json:"derivesFrom"`
 }
 
 type SaveTrack struct {
-	Waypoints Waypoints
-	Id        string
-	Name      string
-	Comment   string
-	Parents   []string
+	Waypoints   Waypoints
+	Id          string
+	Name        string
+	Comment     string
+	Parents     []string
+	DerivesFrom string
 }
 
 type JournalEntry struct {
@@ -109,4 +112,30 @@ type JournalEntry struct {
 	CustomLength *int      `json:"customLength"`
 	Laps         int       `json:"laps"`
 	Time         string    `json:"time"`
+	Weather      *Weather  `json:"weather"`
+	// EmbeddedTrack is a copy of the track the entry referenced before the track was deleted; TrackId is empty then
+	EmbeddedTrack *Track `json:"embeddedTrack,omitempty"`
+}
+
+func (j JournalEntry) Duration() (time.Duration, error) {
+	var hours, minutes, seconds int
+	_, err := fmt.Sscanf(j.Time, "%d:%d:%d", &hours, &minutes, &seconds)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse time \"%s\": %v", j.Time, err)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+}
+
+type Weather struct {
+	Temperature   float64 `json:"temperature"`
+	WindSpeed     float64 `json:"windSpeed"`
+	Precipitation float64 `json:"precipitation"`
+	Conditions    string  `json:"conditions"`
+}
+
+type Attachment struct {
+	EntryId      string
+	Name         string
+	Size         int64
+	HasThumbnail bool
 }
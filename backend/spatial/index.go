@@ -0,0 +1,162 @@
+package spatial
+
+import (
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"math"
+	"slices"
+	"sync"
+)
+
+type cell struct {
+	x int
+	y int
+}
+
+type cellRange struct {
+	min cell
+	max cell
+}
+
+func (r cellRange) intersect(other cellRange) cellRange {
+	return cellRange{
+		min: cell{x: max(r.min.x, other.min.x), y: max(r.min.y, other.min.y)},
+		max: cell{x: min(r.max.x, other.max.x), y: min(r.max.y, other.max.y)},
+	}
+}
+
+func (r cellRange) extend(other cellRange) cellRange {
+	return cellRange{
+		min: cell{x: min(r.min.x, other.min.x), y: min(r.min.y, other.min.y)},
+		max: cell{x: max(r.max.x, other.max.x), y: max(r.max.y, other.max.y)},
+	}
+}
+
+// Index is a uniform grid over bounding boxes. Every bounding box is registered in all cells it touches,
+// thus, a search only has to check the boxes of the cells touched by the searched area.
+type Index struct {
+	sync.RWMutex
+	cellSize float64
+	cells    map[cell][]string
+	boxes    map[string]shared.BoundingBox
+	// the cells touched by any box; it only grows until the index is empty, searches outside are skipped
+	extent cellRange
+}
+
+// NewIndex creates an index whose cells are cellSize degrees wide and high
+func NewIndex(cellSize float64) *Index {
+	return &Index{cellSize: cellSize, cells: make(map[cell][]string), boxes: make(map[string]shared.BoundingBox)}
+}
+
+func (i *Index) Insert(id string, box shared.BoundingBox) {
+	i.Lock()
+	defer i.Unlock()
+	i.remove(id)
+	cells := i.cellRange(box)
+	if len(i.boxes) == 0 {
+		i.extent = cells
+	} else {
+		i.extent = i.extent.extend(cells)
+	}
+	i.boxes[id] = box
+	forEachCell(
+		cells, func(c cell) {
+			i.cells[c] = append(i.cells[c], id)
+		},
+	)
+}
+
+func (i *Index) Remove(id string) {
+	i.Lock()
+	defer i.Unlock()
+	i.remove(id)
+}
+
+// ReplaceWith takes over the boxes of the other index, which must not be used afterward
+func (i *Index) ReplaceWith(other *Index) {
+	other.RLock()
+	defer other.RUnlock()
+	i.Lock()
+	defer i.Unlock()
+	i.cellSize = other.cellSize
+	i.cells = other.cells
+	i.boxes = other.boxes
+	i.extent = other.extent
+}
+
+func (i *Index) remove(id string) {
+	box, ok := i.boxes[id]
+	if !ok {
+		return
+	}
+	delete(i.boxes, id)
+	forEachCell(
+		i.cellRange(box), func(c cell) {
+			i.cells[c] = slices.DeleteFunc(
+				i.cells[c], func(s string) bool {
+					return s == id
+				},
+			)
+			if len(i.cells[c]) == 0 {
+				delete(i.cells, c)
+			}
+		},
+	)
+}
+
+// Search returns the ids of all bounding boxes intersecting the given box
+func (i *Index) Search(box shared.BoundingBox) []string {
+	i.RLock()
+	defer i.RUnlock()
+	result := make([]string, 0)
+	if len(i.boxes) == 0 {
+		return result
+	}
+	forEachCell(
+		i.cellRange(box).intersect(i.extent), func(c cell) {
+			for _, id := range i.cells[c] {
+				if !slices.Contains(result, id) && i.boxes[id].Intersects(box) {
+					result = append(result, id)
+				}
+			}
+		},
+	)
+	return result
+}
+
+func (i *Index) Get(id string) (shared.BoundingBox, bool) {
+	i.RLock()
+	defer i.RUnlock()
+	box, ok := i.boxes[id]
+	return box, ok
+}
+
+func (i *Index) Boxes() map[string]shared.BoundingBox {
+	i.RLock()
+	defer i.RUnlock()
+	result := make(map[string]shared.BoundingBox, len(i.boxes))
+	for id, box := range i.boxes {
+		result[id] = box
+	}
+	return result
+}
+
+// cellRange returns the cells touched by the box, boxes exceeding the valid coordinates are clamped
+func (i *Index) cellRange(box shared.BoundingBox) cellRange {
+	toCell := func(latitude float64, longitude float64) cell {
+		return cell{
+			x: int(math.Floor(math.Max(math.Min(longitude, 180), -180) / i.cellSize)),
+			y: int(math.Floor(math.Max(math.Min(latitude, 90), -90) / i.cellSize)),
+		}
+	}
+	return cellRange{
+		min: toCell(box.MinLatitude, box.MinLongitude), max: toCell(box.MaxLatitude, box.MaxLongitude),
+	}
+}
+
+func forEachCell(cells cellRange, consumer func(c cell)) {
+	for x := cells.min.x; x <= cells.max.x; x++ {
+		for y := cells.min.y; y <= cells.max.y; y++ {
+			consumer(cell{x: x, y: y})
+		}
+	}
+}
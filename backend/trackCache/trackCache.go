@@ -0,0 +1,117 @@
+package trackCache
+
+import (
+	"container/list"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"slices"
+	"sync"
+)
+
+// Track is a parsed track together with values derived from its waypoints. The waypoints are shared
+// between all readers of the cache and must not be modified.
+type Track struct {
+	shared.Track
+	Length      int
+	BoundingBox shared.BoundingBox
+}
+
+// Cache keeps the most recently used tracks in memory so that their GPX files need not be parsed again.
+// Tracks are evicted when they are saved or deleted.
+type Cache struct {
+	mutex      sync.Mutex
+	service    *filebased.Service
+	capacity   int
+	elements   map[string]*list.Element
+	recency    *list.List
+	generation int
+}
+
+func New(bus *shared.EventBus, service *filebased.Service, capacity int) *Cache {
+	result := &Cache{
+		service:  service,
+		capacity: max(capacity, 1),
+		elements: make(map[string]*list.Element),
+		recency:  list.New(),
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			result.evict(event.Id)
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			result.evict(event.Id)
+		},
+	)
+	return result
+}
+
+func (c *Cache) Get(id string) (Track, error) {
+	c.mutex.Lock()
+	if element, ok := c.elements[id]; ok {
+		c.recency.MoveToFront(element)
+		c.mutex.Unlock()
+		return element.Value.(Track), nil
+	}
+	generation := c.generation
+	c.mutex.Unlock()
+
+	read, err := c.service.ReadTrack(id)
+	if err != nil {
+		return Track{}, err
+	}
+	track := trackOf(read)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// the track might have been changed while it was read
+	if generation != c.generation {
+		return track, nil
+	}
+	if element, ok := c.elements[id]; ok {
+		c.recency.MoveToFront(element)
+		return element.Value.(Track), nil
+	}
+	c.elements[id] = c.recency.PushFront(track)
+	for c.recency.Len() > c.capacity {
+		oldest := c.recency.Back()
+		c.recency.Remove(oldest)
+		delete(c.elements, oldest.Value.(Track).Id)
+	}
+	return track, nil
+}
+
+// OfEntry returns the track of the journal entry; tracks embedded into the entry are not cached
+func (c *Cache) OfEntry(entry shared.JournalEntry) (Track, error) {
+	if entry.EmbeddedTrack != nil {
+		return trackOf(*entry.EmbeddedTrack), nil
+	}
+	return c.Get(entry.TrackId)
+}
+
+func trackOf(track shared.Track) Track {
+	return Track{Track: track, Length: track.Waypoints.Length(), BoundingBox: track.Waypoints.BoundingBox()}
+}
+
+// ReadTrack returns a copy of the track whose waypoints may be modified by the caller
+func (c *Cache) ReadTrack(id string) (shared.Track, error) {
+	track, err := c.Get(id)
+	if err != nil {
+		return shared.Track{}, err
+	}
+	result := track.Track
+	result.Waypoints = slices.Clone(track.Waypoints)
+	result.Parents = slices.Clone(track.Parents)
+	return result, nil
+}
+
+func (c *Cache) evict(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation = c.generation + 1
+	if element, ok := c.elements[id]; ok {
+		c.recency.Remove(element)
+		delete(c.elements, id)
+	}
+}
@@ -0,0 +1,169 @@
+package trackMatcher
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/projection"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/spatial"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"log"
+	"math"
+	"slices"
+	"strings"
+)
+
+// maximal distance (in meters) between a point of the trace and a track for the point to be on the track
+const tolerance = 30
+
+// the waypoints of tracks are densified to this spacing (in meters) before comparison
+const comparisonSpacing = 20
+
+// tracks whose score is below this value are not considered to be a match
+const minimalScore = 0.25
+
+// index cells are 0.05 degrees wide, i.e. about 5 km
+const cellSize = 0.05
+
+type Match struct {
+	Track trackCache.Track
+	// share of the trace that lies on the track
+	Overlap float64
+	// share of the track that is covered by the trace
+	Coverage float64
+	Laps     int
+	Score    float64
+}
+
+// Matcher finds the tracks a recorded trace most likely followed. It keeps a spatial index of the tracks'
+// bounding boxes in order to compare the trace only with tracks in its vicinity.
+type Matcher struct {
+	tracks *trackCache.Cache
+	index  *spatial.Index
+}
+
+func New(tracks *trackCache.Cache) *Matcher {
+	return &Matcher{tracks: tracks, index: spatial.NewIndex(cellSize)}
+}
+
+func (m *Matcher) ProjectionName() string {
+	return "trackBoundingBoxes"
+}
+
+func (m *Matcher) Version() int {
+	return 1
+}
+
+func (m *Matcher) Init(bus *shared.EventBus, message json.RawMessage, writer func()) {
+	if message != nil {
+		boxes := make(map[string]shared.BoundingBox)
+		_ = json.Unmarshal(message, &boxes)
+		for id, box := range boxes {
+			m.index.Insert(id, box)
+		}
+	}
+	shared.Listen(
+		bus, shared.TrackUpsertedEvent{}, func(event shared.TrackUpsertedEvent) {
+			m.index.Insert(event.Id, event.Waypoints.BoundingBox())
+			writer()
+		},
+	)
+	shared.Listen(
+		bus, shared.TrackDeletedEvent{}, func(event shared.TrackDeletedEvent) {
+			m.index.Remove(event.Id)
+			writer()
+		},
+	)
+}
+
+func (m *Matcher) Reset() {
+	for id := range m.index.Boxes() {
+		m.index.Remove(id)
+	}
+}
+
+func (m *Matcher) Fresh() projection.Projector {
+	return New(m.tracks)
+}
+
+func (m *Matcher) Swap(fresh projection.Projector) {
+	m.index.ReplaceWith(fresh.(*Matcher).index)
+}
+
+func (m *Matcher) AddTrack(track shared.Track) {
+	m.index.Insert(track.Id, track.Waypoints.BoundingBox())
+}
+
+func (m *Matcher) AddJournalEntry(entry shared.JournalEntry) {}
+
+func (m *Matcher) GetData() any {
+	return m.index.Boxes()
+}
+
+// Match compares the trace with all tracks near it and returns at most limit matches, the best match first
+func (m *Matcher) Match(trace shared.Waypoints, limit int) ([]Match, error) {
+	if len(trace) < 2 {
+		return nil, fmt.Errorf("the trace must contain at least two points, but contains %d", len(trace))
+	}
+	densifiedTrace, err := trace.Densify(comparisonSpacing)
+	if err != nil {
+		return nil, fmt.Errorf("could not densify the trace: %v", err)
+	}
+	result := make([]Match, 0)
+	for _, id := range m.index.Search(trace.BoundingBox().Expand(tolerance)) {
+		track, err := m.tracks.Get(id)
+		if err != nil {
+			log.Printf("could not read track %s for matching: %v", id, err)
+			continue
+		}
+		match, ok := compare(densifiedTrace, track)
+		if ok {
+			result = append(result, match)
+		}
+	}
+	slices.SortFunc(
+		result, func(a, b Match) int {
+			if a.Score != b.Score {
+				return cmp.Compare(b.Score, a.Score)
+			}
+			return strings.Compare(a.Track.Id, b.Track.Id)
+		},
+	)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func compare(trace shared.Waypoints, track trackCache.Track) (Match, bool) {
+	if len(track.Waypoints) < 2 {
+		return Match{}, false
+	}
+	densifiedTrack, _ := track.Waypoints.Densify(comparisonSpacing)
+	coverage := densifiedTrack.Coverage(trace, tolerance)
+	matchedDistance := 0.0
+	onTrack := 0
+	previousOnTrack := false
+	for index, point := range trace {
+		_, offset := track.Waypoints.Locate(point)
+		isOnTrack := offset <= tolerance
+		if isOnTrack {
+			onTrack++
+		}
+		if isOnTrack && previousOnTrack {
+			matchedDistance = matchedDistance + trace[index-1].DistanceTo(point)
+		}
+		previousOnTrack = isOnTrack
+	}
+	overlap := float64(onTrack) / float64(len(trace))
+	score := overlap * coverage
+	if score < minimalScore {
+		return Match{}, false
+	}
+	laps := 1
+	if track.Length > 0 {
+		laps = max(1, int(math.Round(matchedDistance/float64(track.Length))))
+	}
+	return Match{Track: track, Overlap: overlap, Coverage: coverage, Laps: laps, Score: score}, true
+}
@@ -0,0 +1,423 @@
+package undo
+
+import (
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// at most this many changes can be undone
+const maxChanges = 50
+
+type Kind int
+
+const (
+	Track Kind = iota
+	JournalEntry
+)
+
+// Object is a track or journal entry that is saved or deleted by a change
+type Object struct {
+	Kind    Kind
+	Id      string
+	deleted bool
+}
+
+func Saved(kind Kind, id string) Object {
+	return Object{Kind: kind, Id: id}
+}
+
+func Deleted(kind Kind, id string) Object {
+	return Object{Kind: kind, Id: id, deleted: true}
+}
+
+// Stack keeps the changes of the current session that can be undone and redone. The states of saved objects
+// are kept in a directory, only their files without attachments are copied. Deleted objects are restored
+// from the trash and moved to the trash again.
+type Stack struct {
+	mutex     sync.Mutex
+	bus       *shared.EventBus
+	service   *filebased.Service
+	directory string
+	undo      []*Change
+	redo      []*Change
+	counter   int
+}
+
+type State struct {
+	CanUndo         bool   `json:"canUndo"`
+	CanRedo         bool   `json:"canRedo"`
+	UndoDescription string `json:"undoDescription"`
+	RedoDescription string `json:"redoDescription"`
+}
+
+// Change is a group of saves and deletes that is undone and redone at once
+type Change struct {
+	stack       *Stack
+	description string
+	directory   string
+	objects     []Object
+	// whether the object existed before and after the change
+	existedBefore []bool
+	existsAfter   []bool
+	finished      bool
+}
+
+func New(bus *shared.EventBus, service *filebased.Service, directory string) *Stack {
+	// the changes of the last session cannot be undone anymore
+	_ = os.RemoveAll(directory)
+	return &Stack{bus: bus, service: service, directory: directory}
+}
+
+// Close removes the recorded states; the changes cannot be undone afterward
+func (s *Stack) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.undo = nil
+	s.redo = nil
+	return os.RemoveAll(s.directory)
+}
+
+// Begin records the state of the objects before the change; the change must be committed or discarded
+// after it was carried out
+func (s *Stack) Begin(description string, objects ...Object) (*Change, error) {
+	s.mutex.Lock()
+	s.counter = s.counter + 1
+	change := &Change{
+		stack:         s,
+		description:   description,
+		directory:     filepath.Join(s.directory, strconv.Itoa(s.counter)),
+		objects:       objects,
+		existedBefore: make([]bool, len(objects)),
+		existsAfter:   make([]bool, len(objects)),
+	}
+	s.mutex.Unlock()
+	for index, object := range objects {
+		source := s.objectDirectory(object)
+		_, err := os.Stat(source)
+		change.existedBefore[index] = err == nil
+		if err != nil || object.deleted {
+			continue
+		}
+		err = copyFiles(source, change.slot("before", index), objectFiles(object))
+		if err != nil {
+			change.Discard()
+			return nil, fmt.Errorf("could not record state of %s before the change: %v", object.Id, err)
+		}
+	}
+	return change, nil
+}
+
+// Discard forgets the change, e.g. because it failed; it does nothing if the change was committed
+func (c *Change) Discard() {
+	if c.finished {
+		return
+	}
+	c.finished = true
+	_ = os.RemoveAll(c.directory)
+}
+
+// Commit records the state of the objects after the change and puts the change on the undo stack
+func (c *Change) Commit() {
+	if c.finished {
+		return
+	}
+	c.finished = true
+	for index, object := range c.objects {
+		if object.deleted {
+			continue
+		}
+		err := copyFiles(c.stack.objectDirectory(object), c.slot("after", index), objectFiles(object))
+		if err != nil {
+			log.Printf("could not record state of %s after the change, it cannot be undone: %v", object.Id, err)
+			_ = os.RemoveAll(c.directory)
+			return
+		}
+		c.existsAfter[index] = true
+	}
+	c.stack.mutex.Lock()
+	defer c.stack.mutex.Unlock()
+	for _, change := range c.stack.redo {
+		_ = os.RemoveAll(change.directory)
+	}
+	c.stack.redo = nil
+	c.stack.undo = append(c.stack.undo, c)
+	if len(c.stack.undo) > maxChanges {
+		_ = os.RemoveAll(c.stack.undo[0].directory)
+		c.stack.undo = c.stack.undo[1:]
+	}
+}
+
+// Revert restores the state before the change and discards it, e.g. because the change failed halfway
+func (c *Change) Revert() error {
+	if c.finished {
+		return fmt.Errorf("the change %s is finished already", c.description)
+	}
+	c.stack.mutex.Lock()
+	err := c.stack.apply(c, reversed(len(c.objects)), "before", c.existedBefore, "after")
+	c.stack.mutex.Unlock()
+	c.Discard()
+	if err != nil {
+		return fmt.Errorf("could not revert %s: %v", c.description, err)
+	}
+	return nil
+}
+
+func (c *Change) slot(state string, index int) string {
+	return filepath.Join(c.directory, state, strconv.Itoa(index))
+}
+
+func (s *Stack) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := State{CanUndo: len(s.undo) > 0, CanRedo: len(s.redo) > 0}
+	if result.CanUndo {
+		result.UndoDescription = s.undo[len(s.undo)-1].description
+	}
+	if result.CanRedo {
+		result.RedoDescription = s.redo[len(s.redo)-1].description
+	}
+	return result
+}
+
+// Undo restores the state before the last change
+func (s *Stack) Undo() (State, error) {
+	s.mutex.Lock()
+	if len(s.undo) == 0 {
+		s.mutex.Unlock()
+		return s.State(), fmt.Errorf("there is nothing to undo")
+	}
+	change := s.undo[len(s.undo)-1]
+	err := s.apply(change, reversed(len(change.objects)), "before", change.existedBefore, "after")
+	if err == nil {
+		s.undo = s.undo[:len(s.undo)-1]
+		s.redo = append(s.redo, change)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return s.State(), fmt.Errorf("could not undo %s: %v", change.description, err)
+	}
+	return s.State(), nil
+}
+
+// Redo carries out the last undone change again
+func (s *Stack) Redo() (State, error) {
+	s.mutex.Lock()
+	if len(s.redo) == 0 {
+		s.mutex.Unlock()
+		return s.State(), fmt.Errorf("there is nothing to redo")
+	}
+	change := s.redo[len(s.redo)-1]
+	indices := make([]int, len(change.objects))
+	for index := range indices {
+		indices[index] = index
+	}
+	err := s.apply(change, indices, "after", change.existsAfter, "before")
+	if err == nil {
+		s.redo = s.redo[:len(s.redo)-1]
+		s.undo = append(s.undo, change)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return s.State(), fmt.Errorf("could not redo %s: %v", change.description, err)
+	}
+	return s.State(), nil
+}
+
+// apply restores the objects to the target state. A saved object that must not exist is moved into the slot
+// of the state that is left, thus it can be restored completely, including its attachments, later on.
+// Deleted objects are moved between their location and the trash.
+func (s *Stack) apply(change *Change, indices []int, target string, exists []bool, left string) error {
+	for _, index := range indices {
+		object := change.objects[index]
+		directory := s.objectDirectory(object)
+		_, statError := os.Stat(directory)
+		existsNow := statError == nil
+		current, _ := s.read(object)
+		var err error
+		switch {
+		case object.deleted && !exists[index] && existsNow:
+			err = s.moveToTrash(object, "redo of "+change.description)
+		case object.deleted && exists[index] && !existsNow:
+			err = s.restoreFromTrash(object)
+		case object.deleted:
+			// the object is in the target state already, e.g. because it was restored from the trash manually
+		case !exists[index] && existsNow:
+			slot := change.slot(left, index)
+			_ = os.RemoveAll(slot)
+			err = move(directory, slot)
+		case exists[index] && !existsNow:
+			err = move(change.slot(target, index), directory)
+			if os.IsNotExist(err) {
+				// the slot was moved back already, e.g. because the object is changed twice by the change
+				err = nil
+			}
+		case exists[index]:
+			err = copyFiles(change.slot(target, index), directory, objectFiles(object))
+		}
+		if err != nil {
+			return fmt.Errorf("could not restore %s: %v", object.Id, err)
+		}
+		err = s.sendEvent(object, current, existsNow, exists[index])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reversed returns the indices of count objects from the last to the first
+func reversed(count int) []int {
+	result := make([]int, count)
+	for index := range result {
+		result[index] = count - 1 - index
+	}
+	return result
+}
+
+func (s *Stack) moveToTrash(object Object, reason string) error {
+	if object.Kind == Track {
+		return s.service.DeleteTrackDirectory(object.Id, reason)
+	}
+	return s.service.DeleteJournalEntry(object.Id, reason)
+}
+
+func (s *Stack) restoreFromTrash(object Object) error {
+	kind := filebased.TrashedTrack
+	if object.Kind == JournalEntry {
+		kind = filebased.TrashedJournalEntry
+	}
+	item, err := s.service.LatestTrashItem(kind, object.Id)
+	if err != nil {
+		return err
+	}
+	_, err = s.service.RestoreFromTrash(item.Id)
+	return err
+}
+
+func (s *Stack) read(object Object) (any, error) {
+	if object.Kind == Track {
+		return s.service.ReadTrack(object.Id)
+	}
+	return s.service.ReadJournalEntry(object.Id)
+}
+
+// sendEvent informs the listeners about the restored object, previous is the object before it was restored
+func (s *Stack) sendEvent(object Object, previous any, existed bool, exists bool) error {
+	if !existed && !exists {
+		return nil
+	}
+	if !exists {
+		if object.Kind == Track {
+			return s.bus.Send(shared.TrackDeletedEvent{Id: object.Id})
+		}
+		entry := previous.(shared.JournalEntry)
+		return s.bus.Send(shared.JournalEntryDeletedEvent{JournalEntry: &entry})
+	}
+	restored, err := s.read(object)
+	if err != nil {
+		return fmt.Errorf("could not read restored %s: %v", object.Id, err)
+	}
+	if object.Kind == Track {
+		track := restored.(shared.Track)
+		return s.bus.Send(
+			shared.TrackUpsertedEvent{
+				SaveTrack: &shared.SaveTrack{
+					Id: track.Id, Name: track.Name, Comment: track.Comment, Parents: track.Parents,
+					Waypoints: track.Waypoints, DerivesFrom: track.DerivesFrom,
+				},
+			},
+		)
+	}
+	entry := restored.(shared.JournalEntry)
+	event := shared.JournalEntryUpsertedEvent{JournalEntry: &entry}
+	if existed {
+		old := previous.(shared.JournalEntry)
+		event.OldTrackId = old.TrackId
+		event.OldDate = &old.Date
+	}
+	return s.bus.Send(event)
+}
+
+func (s *Stack) objectDirectory(object Object) string {
+	if object.Kind == Track {
+		return s.service.TrackDirectory(object.Id)
+	}
+	return s.service.JournalEntryDirectory(object.Id)
+}
+
+func objectFiles(object Object) []string {
+	if object.Kind == Track {
+		return filebased.TrackFiles
+	}
+	return filebased.JournalEntryFiles
+}
+
+// move renames the source; if that fails, e.g. because the stack's directory is on another file system, the
+// source is copied and removed
+func move(source string, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(source, target)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	err = copyDirectory(source, target)
+	if err != nil {
+		_ = os.RemoveAll(target)
+		return err
+	}
+	return os.RemoveAll(source)
+}
+
+func copyFiles(source string, target string, names []string) error {
+	err := os.MkdirAll(target, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(source, name))
+		if os.IsNotExist(err) {
+			// optional files that did not exist in the copied state must not exist in the target either
+			err = os.Remove(filepath.Join(target, name))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(target, name), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyDirectory(source string, target string) error {
+	return filepath.WalkDir(
+		source, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			relative, _ := filepath.Rel(source, path)
+			if entry.IsDir() {
+				return os.MkdirAll(filepath.Join(target, relative), os.ModePerm)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(target, relative), content, 0644)
+		},
+	)
+}
@@ -0,0 +1,123 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Provider struct {
+	url       atomic.Pointer[string]
+	autoFetch atomic.Bool
+}
+
+// the provider is expected to answer in the format of the Open-Meteo API (https://open-meteo.com/en/docs),
+// thus, a local stand-in only needs to serve the "daily" object with the requested values
+type providerResponse struct {
+	Daily struct {
+		Time          []string   `json:"time"`
+		Temperature   []*float64 `json:"temperature_2m_mean"`
+		WindSpeed     []*float64 `json:"wind_speed_10m_max"`
+		Precipitation []*float64 `json:"precipitation_sum"`
+		WeatherCode   []*int     `json:"weather_code"`
+	} `json:"daily"`
+}
+
+func NewProvider(bus *shared.EventBus, url string, autoFetch bool) *Provider {
+	result := &Provider{}
+	result.url.Store(&url)
+	result.autoFetch.Store(autoFetch)
+	shared.Listen(bus, shared.WeatherProviderChangedEvent{}, func(k shared.WeatherProviderChangedEvent) {
+		result.url.Store(&k.NewValue)
+	})
+	shared.Listen(bus, shared.WeatherAutoFetchChangedEvent{}, func(k shared.WeatherAutoFetchChangedEvent) {
+		result.autoFetch.Store(k.NewValue)
+	})
+	return result
+}
+
+func (p *Provider) AutoFetch() bool {
+	return p.autoFetch.Load() && *p.url.Load() != ""
+}
+
+func (p *Provider) Fetch(coordinates shared.Coordinates, date time.Time) (shared.Weather, error) {
+	url := *p.url.Load()
+	if url == "" {
+		return shared.Weather{}, fmt.Errorf("no weather provider configured")
+	}
+	url = strings.ReplaceAll(url, "{lat}", strconv.FormatFloat(coordinates.Latitude, 'f', 4, 64))
+	url = strings.ReplaceAll(url, "{lon}", strconv.FormatFloat(coordinates.Longitude, 'f', 4, 64))
+	url = strings.ReplaceAll(url, "{date}", date.Format(time.DateOnly))
+	client := http.Client{Timeout: time.Second * 10}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return shared.Weather{}, fmt.Errorf("could not create weather request: %v", err)
+	}
+	req.Header.Set("User-Agent", "github.com/fafeitsch/private-running-journal")
+	response, err := client.Do(req)
+	if err != nil {
+		return shared.Weather{}, fmt.Errorf("could not get weather from provider: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return shared.Weather{}, fmt.Errorf("weather provider answered with status %d", response.StatusCode)
+	}
+	var payload providerResponse
+	err = json.NewDecoder(response.Body).Decode(&payload)
+	if err != nil {
+		return shared.Weather{}, fmt.Errorf("could not parse weather: %v", err)
+	}
+	daily := payload.Daily
+	index := -1
+	for i, day := range daily.Time {
+		if day == date.Format(time.DateOnly) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return shared.Weather{}, fmt.Errorf("weather provider did not return data for %s", date.Format(time.DateOnly))
+	}
+	result := shared.Weather{
+		Temperature:   valueAt(daily.Temperature, index),
+		WindSpeed:     valueAt(daily.WindSpeed, index),
+		Precipitation: valueAt(daily.Precipitation, index),
+	}
+	if index < len(daily.WeatherCode) && daily.WeatherCode[index] != nil {
+		result.Conditions = conditionsFromCode(*daily.WeatherCode[index])
+	}
+	return result, nil
+}
+
+func valueAt(values []*float64, index int) float64 {
+	if index >= len(values) || values[index] == nil {
+		return 0
+	}
+	return *values[index]
+}
+
+// see the WMO weather interpretation codes in https://open-meteo.com/en/docs
+func conditionsFromCode(code int) string {
+	switch {
+	case code == 0:
+		return "clear"
+	case code <= 3:
+		return "cloudy"
+	case code == 45 || code == 48:
+		return "fog"
+	case code >= 51 && code <= 57:
+		return "drizzle"
+	case (code >= 61 && code <= 67) || (code >= 80 && code <= 82):
+		return "rain"
+	case (code >= 71 && code <= 77) || code == 85 || code == 86:
+		return "snow"
+	case code >= 95:
+		return "thunderstorm"
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fafeitsch/private-running-journal/backend/filebased"
+	"github.com/fafeitsch/private-running-journal/backend/query"
+	"github.com/fafeitsch/private-running-journal/backend/shared"
+	"github.com/fafeitsch/private-running-journal/backend/trackCache"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	homeDir, _ := os.UserHomeDir()
+	directory := flag.String(
+		"dir", filepath.Join(homeDir, ".private-running-journal"), "the config directory of the running journal",
+	)
+	order := flag.String("sort", "", "sort by date, distance, time, track, or laps; prefix with - for descending order")
+	page := flag.Int("page", 0, "the zero-based page to print")
+	pageSize := flag.Int("size", 0, "the number of entries per page, 0 prints all entries")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <query>\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "example: %s 'track:\"Forest loop\" distance>10km date:2024'\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetOutput(os.Stderr)
+
+	parsed, err := query.Parse(strings.Join(flag.Args(), " "))
+	if err != nil {
+		log.Fatalf("could not parse query: %v", err)
+	}
+	service := filebased.NewService(*directory)
+	entries, err := service.ReadAllJournalEntries()
+	if err != nil {
+		log.Fatalf("could not read journal entries: %v", err)
+	}
+	matches := make([]query.Candidate, 0)
+	for _, candidate := range query.Candidates(entries, trackCache.New(shared.NewEventBus(), service, math.MaxInt)) {
+		if parsed.Matches(candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	if *order == "" {
+		*order = parsed.Order
+	}
+	err = query.Sort(matches, *order)
+	if err != nil {
+		log.Fatalf("could not sort entries: %v", err)
+	}
+	for _, candidate := range query.Page(matches, *page, *pageSize) {
+		fmt.Printf(
+			"%s\t%-30s\t%6.2f km\t%8s\t%s\n", candidate.Entry.Date.Format(time.DateOnly), candidate.TrackName,
+			float64(candidate.Length)/1000, candidate.Entry.Time, candidate.Entry.Id,
+		)
+	}
+	fmt.Fprintf(os.Stderr, "%d matching entries\n", len(matches))
+}
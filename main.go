@@ -33,6 +33,7 @@ func main() {
 			},
 			BackgroundColour: options.NewRGB(uint8(255), uint8(255), uint8(255)),
 			OnStartup:        app.Startup,
+			OnShutdown:       app.Shutdown,
 			OnDomReady: func(ctx context.Context) {
 				if !app.HeadlessMode() {
 					runtime.Show(ctx)
@@ -40,7 +41,8 @@ func main() {
 			},
 			StartHidden: true,
 			Bind: []interface{}{
-				app, app.TrackEditor(), app.JournalEditor(), app.DashboardAssembler(),
+				app, app.TrackEditor(), app.JournalEditor(), app.DashboardAssembler(), app.Calendar(),
+				app.DuplicateFinder(), app.TrashBin(),
 			},
 		},
 	)